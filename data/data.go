@@ -0,0 +1,32 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+//go:build igrdata
+
+package data
+
+import (
+	"embed"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+)
+
+// DefaultXdbName is the name of the default xdb file in FS
+const DefaultXdbName = "igr.xdb"
+
+// FS holds the default xdb file
+//
+//go:embed igr.xdb
+var FS embed.FS
+
+// NewSearcher create a searcher of the embedded default xdb with the cache policy,
+// CACHE_POLICY_MMAP is not supported.
+func NewSearcher(cachePolicy xdb.CachePolicy, opts ...xdb.Option) (*xdb.Searcher, error) {
+	return xdb.CreateFromFS(FS, DefaultXdbName, cachePolicy, opts...)
+}
+
+// NewDefaultSearcher create a searcher of the embedded default xdb with CACHE_POLICY_MEMORY
+func NewDefaultSearcher() (*xdb.Searcher, error) {
+	return NewSearcher(xdb.CACHE_POLICY_MEMORY)
+}
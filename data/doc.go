@@ -0,0 +1,9 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// Package data embeds the default xdb file `data/igr.xdb` of the project,
+// so the tools could search without a data file shipped next to the binary.
+// the xdb file is not tracked, generate it with `make gen` and build with the `igrdata` tag,
+// rebuild the binary after the xdb file regenerated.
+package data
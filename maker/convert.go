@@ -0,0 +1,288 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// convert between the original ip2region xdb and the igr xdb.
+// the segments of the source xdb are walked with the segment iterator:
+// -- ip2region -> igr: the segments are fed to the maker as the source lines, since the full
+// region string of the original xdb is the same as the region of the ip.merge.txt line
+// -- igr -> ip2region: the segments are written with the original layout, and the area field
+// dropped by the maker is filled with the empty placeholder
+// the equivalence check samples the ips of both the xdb files after the conversion.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+)
+
+// the mismatches kept as the examples of the equivalence check
+const maxMismatchExamples = 20
+
+// segmentSource stream the segments of the ipv4 xdb as the source lines of the maker
+func segmentSource(searcher *xdb.Searcher) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		var buff = bufio.NewWriter(writer)
+		it := searcher.NewSegmentIterator()
+		for it.Next() {
+			_, err := buff.WriteString(it.Segment().String() + "\n")
+			if err != nil {
+				_ = writer.CloseWithError(err)
+				return
+			}
+		}
+
+		if err := it.Err(); err != nil {
+			_ = writer.CloseWithError(err)
+			return
+		}
+
+		_ = writer.CloseWithError(buff.Flush())
+	}()
+
+	return reader
+}
+
+// makeFromSegments make the igr xdb from the segments of the source xdb,
+// the gaps of the source are kept, and the setup is applied to the maker before the build
+func makeFromSegments(src *xdb.Searcher, srcName string, dstFile string, policy xdb.IndexPolicy, setup func(*Maker)) error {
+	var source = segmentSource(src)
+	maker, err := NewMakerWithReader(policy, source, srcName, dstFile)
+	if err != nil {
+		_ = source.Close()
+		return fmt.Errorf("create maker: %w", err)
+	}
+
+	maker.SetAllowGaps(true)
+	if setup != nil {
+		setup(maker)
+	}
+
+	err = maker.Init()
+	if err == nil {
+		err = maker.Start()
+	}
+	if endErr := maker.End(); err == nil {
+		err = endErr
+	}
+
+	return err
+}
+
+// upstreamRegion restore the region of the igr xdb as the full region of the original xdb,
+// the area field dropped by the maker is filled with the empty placeholder
+func upstreamRegion(region string, layout []string) string {
+	if len(layout) == 0 || layout[0] != xdb.REGION_FIELD_COUNTRY {
+		return region
+	}
+
+	for _, field := range layout {
+		if field == xdb.REGION_FIELD_AREA {
+			return region
+		}
+	}
+
+	pieces := strings.SplitN(region, xdb.REGION_STR_SEP, 2)
+	if len(pieces) < 2 {
+		return region
+	}
+
+	return pieces[0] + xdb.REGION_STR_SEP + xdb.REGION_EMPTY_FIELD + xdb.REGION_STR_SEP + pieces[1]
+}
+
+// writeUpstream write the segments of the igr xdb into the dstFile with the layout of the original
+// ip2region maker, the segment index blocks are split with the pre-two bytes the same as the original one.
+// it returns the count of the segments written.
+func writeUpstream(searcher *xdb.Searcher, dstFile string, createdAt time.Time) (int, error) {
+	var header = searcher.GetHeader()
+	if header.IPVersion != xdb.IPv4 {
+		return 0, fmt.Errorf("%w: the original xdb is ipv4 only", xdb.ErrIPVersionMismatch)
+	}
+
+	if header.IsPartial() {
+		return 0, fmt.Errorf("the original xdb should cover the whole ip space, while the source xdb is partial")
+	}
+
+	var buff = make([]byte, xdb.HeaderInfoLength+xdb.VectorIndexLength)
+	binary.LittleEndian.PutUint16(buff, xdb.VersionNo)
+	binary.LittleEndian.PutUint16(buff[2:], uint16(xdb.VectorIndexPolicy))
+	binary.LittleEndian.PutUint32(buff[4:], uint32(createdAt.Unix()))
+
+	// the regions are written in the order of the segments
+	type segment struct {
+		startIP uint32
+		endIP   uint32
+		region  string
+	}
+	var segments []segment
+	var regionPtrs = map[string]uint32{}
+	it := searcher.NewSegmentIterator()
+	for it.Next() {
+		var seg = it.Segment()
+		var region = upstreamRegion(seg.Region(), header.FieldLayout)
+		if len(region) > 0xFFFF {
+			return 0, fmt.Errorf("too long region `%s` of segment `%s`", region, seg)
+		}
+
+		if _, has := regionPtrs[region]; !has {
+			regionPtrs[region] = uint32(len(buff))
+			buff = append(buff, region...)
+		}
+
+		segments = append(segments, segment{seg.StartIP, seg.EndIP, region})
+	}
+	if err := it.Err(); err != nil {
+		return 0, fmt.Errorf("walk the segments: %w", err)
+	}
+
+	var startIndexPtr, endIndexPtr = -1, -1
+	for _, seg := range segments {
+		var region = seg.region
+		for sip := seg.startIP; ; {
+			var eip = sip | xdb.IP_TAIL_PATTERN
+			if eip > seg.endIP {
+				eip = seg.endIP
+			}
+
+			var ptr = len(buff)
+			buff = binary.LittleEndian.AppendUint32(buff, sip)
+			buff = binary.LittleEndian.AppendUint32(buff, eip)
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(len(region)))
+			buff = binary.LittleEndian.AppendUint32(buff, regionPtrs[region])
+
+			// the end ptr of the vector index is the end of the last block
+			var idx = xdb.HeaderInfoLength + (sip>>16)*xdb.VectorIndexSize
+			if binary.LittleEndian.Uint32(buff[idx:]) == 0 {
+				binary.LittleEndian.PutUint32(buff[idx:], uint32(ptr))
+			}
+			binary.LittleEndian.PutUint32(buff[idx+4:], uint32(ptr+xdb.UpstreamIndexBlockSize))
+
+			if startIndexPtr == -1 {
+				startIndexPtr = ptr
+			}
+			endIndexPtr = ptr
+
+			if eip == seg.endIP {
+				break
+			}
+			sip = eip + 1
+		}
+	}
+
+	if startIndexPtr == -1 {
+		return 0, fmt.Errorf("empty segment list")
+	}
+
+	binary.LittleEndian.PutUint32(buff[8:], uint32(startIndexPtr))
+	binary.LittleEndian.PutUint32(buff[12:], uint32(endIndexPtr))
+	err := os.WriteFile(dstFile, buff, 0666)
+	if err != nil {
+		return 0, fmt.Errorf("write `%s`: %w", dstFile, err)
+	}
+
+	return len(segments), nil
+}
+
+// EquivalenceReport is the result of the equivalence check
+type EquivalenceReport struct {
+	// the ips searched in both the xdb files, and the ones with different regions
+	Checked    int
+	Mismatched int
+
+	// the first mismatches as `ip: src region != dst region`
+	Examples []string
+}
+
+// checkEquivalence search the start, end and middle ip of every segment of the src xdb,
+// and the specified count of random ips in both the xdb files, and compare the regions.
+// the regions are compared field by field, the area field is skipped if it is dropped by either one.
+func checkEquivalence(src *xdb.Searcher, dst *xdb.Searcher, samples int) (*EquivalenceReport, error) {
+	var ipVersion = src.GetHeader().IPVersion
+	if dst.GetHeader().IPVersion != ipVersion {
+		return nil, fmt.Errorf("%w: compare a %s xdb with a %s xdb", xdb.ErrIPVersionMismatch, ipVersion, dst.GetHeader().IPVersion)
+	}
+
+	var report = &EquivalenceReport{}
+	var compare = func(ip netip.Addr) error {
+		sRegion, sErr := src.SearchByAddr(ip)
+		dRegion, dErr := dst.SearchByAddr(ip)
+		for _, err := range []error{sErr, dErr} {
+			if err != nil && !errors.Is(err, xdb.ErrNotFound) {
+				return fmt.Errorf("search `%s`: %w", ip, err)
+			}
+		}
+
+		report.Checked++
+		var same = (sErr == nil) == (dErr == nil)
+		if same && sErr == nil {
+			same = sameRegion(xdb.ParseRegion(sRegion, src.GetHeader().FieldLayout), xdb.ParseRegion(dRegion, dst.GetHeader().FieldLayout))
+		}
+
+		if !same {
+			report.Mismatched++
+			if len(report.Examples) < maxMismatchExamples {
+				report.Examples = append(report.Examples, fmt.Sprintf("%s: `%s` != `%s`", ip, sRegion, dRegion))
+			}
+		}
+
+		return nil
+	}
+
+	it := src.NewSegmentIterator()
+	for it.Next() {
+		var seg = it.Segment()
+		var mid = xdb.Long2Addr(xdb.MidIP(seg.StartIP, seg.EndIP))
+		if ipVersion == xdb.IPv6 {
+			mid = netip.AddrFrom16(MidIPv6(seg.StartAddr.As16(), seg.EndAddr.As16()))
+		}
+
+		for _, ip := range []netip.Addr{seg.StartAddr, mid, seg.EndAddr} {
+			if err := compare(ip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("walk the segments: %w", err)
+	}
+
+	// the same random ips for every check
+	var r = rand.New(rand.NewSource(1))
+	for i := 0; i < samples; i++ {
+		var ip = xdb.Long2Addr(r.Uint32())
+		if ipVersion == xdb.IPv6 {
+			var ip6 [16]byte
+			_, _ = r.Read(ip6[:])
+			ip = netip.AddrFrom16(ip6)
+		}
+
+		if err := compare(ip); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// sameRegion compare the structured regions, the area is compared only if both of them have it
+func sameRegion(a *xdb.Region, b *xdb.Region) bool {
+	if a.Area != "" && b.Area != "" && a.Area != b.Area {
+		return false
+	}
+
+	return a.Country == b.Country && a.Province == b.Province && a.City == b.City && a.ISP == b.ISP &&
+		strings.Join(a.Extra, xdb.REGION_STR_SEP) == strings.Join(b.Extra, xdb.REGION_STR_SEP)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	var expect []string
+	for _, line := range testLines {
+		expect = append(expect, igrSegment(line))
+	}
+
+	for _, policy := range []xdb.IndexPolicy{xdb.VectorIndexPolicy, xdb.BTreeIndexPolicy} {
+		igrFile := makeTestDb(t, policy, testLines, nil)
+		src, err := xdb.NewWithFileOnly(igrFile)
+		require.NoError(t, err)
+		defer src.Close()
+
+		// igr -> ip2region, the area placeholder dropped by the maker is restored
+		upstreamFile := filepath.Join(t.TempDir(), "ip2region.xdb")
+		count, err := writeUpstream(src, upstreamFile, testCreatedAt)
+		require.NoError(t, err)
+		assert.Equal(t, len(testLines), count)
+
+		upstream, err := xdb.NewWithFileOnly(upstreamFile)
+		require.NoError(t, err)
+		defer upstream.Close()
+		assert.Equal(t, xdb.UpstreamFormat, upstream.GetHeader().Format)
+		assert.Equal(t, uint32(testCreatedAt.Unix()), upstream.GetHeader().CreatedAt)
+		assert.Equal(t, testLines, readSegments(t, upstreamFile))
+
+		report, err := checkEquivalence(src, upstream, 1000)
+		require.NoError(t, err)
+		assert.Zero(t, report.Mismatched, "%v", report.Examples)
+		assert.Equal(t, 3*len(testLines)+1000, report.Checked)
+
+		// ip2region -> igr, the same segments as the source
+		igrFile2 := filepath.Join(t.TempDir(), "igr.xdb")
+		err = makeFromSegments(upstream, "ip2region.xdb", igrFile2, policy, func(maker *Maker) {
+			maker.SetCreatedAt(testCreatedAt)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, expect, readSegments(t, igrFile2))
+		checkTestDb(t, igrFile2, testLines)
+
+		dst, err := xdb.NewWithFileOnly(igrFile2)
+		require.NoError(t, err)
+		defer dst.Close()
+		assert.Equal(t, policy, dst.GetHeader().IndexPolicy)
+		report, err = checkEquivalence(upstream, dst, 1000)
+		require.NoError(t, err)
+		assert.Zero(t, report.Mismatched, "%v", report.Examples)
+	}
+}
+
+func TestConvertPartial(t *testing.T) {
+	// the original xdb covers the whole ip space, the partial source is refused
+	igrFile := makeTestDb(t, xdb.VectorIndexPolicy, testLines[2:5], func(maker *Maker) {
+		maker.SetAllowGaps(true)
+	})
+	src, err := xdb.NewWithFileOnly(igrFile)
+	require.NoError(t, err)
+	defer src.Close()
+	assert.True(t, src.GetHeader().IsPartial())
+
+	upstreamFile := filepath.Join(t.TempDir(), "ip2region.xdb")
+	_, err = writeUpstream(src, upstreamFile, testCreatedAt)
+	assert.Error(t, err)
+	_, err = os.Stat(upstreamFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// the gaps are searched as not found in both the xdb files
+	full, err := xdb.NewWithFileOnly(makeTestDb(t, xdb.VectorIndexPolicy, testLines, nil))
+	require.NoError(t, err)
+	defer full.Close()
+	report, err := checkEquivalence(src, full, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 9, report.Checked)
+	assert.Zero(t, report.Mismatched)
+
+	report, err = checkEquivalence(full, src, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3*len(testLines), report.Checked)
+	assert.Equal(t, 3*(len(testLines)-3), report.Mismatched)
+}
+
+func TestEquivalenceIPv6(t *testing.T) {
+	var lines = []string{
+		"::|2001:250:ffff:ffff:ffff:ffff:ffff:ffff|0|0|0|0|0",
+		"2001:251::|2001:251::ffff|中国|0|北京|北京市|教育网",
+		"2001:251::1:0|ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff|美国|0|0|0|0",
+	}
+
+	// the same segments with the different index policies
+	src, err := xdb.NewWithFileOnly(makeTestDb(t, xdb.VectorIndexPolicy, lines, nil))
+	require.NoError(t, err)
+	defer src.Close()
+	dst, err := xdb.NewWithFileOnly(makeTestDb(t, xdb.BTreeIndexPolicy, lines, nil))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	report, err := checkEquivalence(src, dst, 1000)
+	require.NoError(t, err)
+	assert.Zero(t, report.Mismatched, "%v", report.Examples)
+	assert.Equal(t, 3*len(lines)+1000, report.Checked)
+
+	// the start, middle and end ip of the changed segment are mismatched
+	var changed = append([]string(nil), lines...)
+	changed[1] = "2001:251::|2001:251::ffff|中国|0|北京|北京市|联通"
+	dst2, err := xdb.NewWithFileOnly(makeTestDb(t, xdb.VectorIndexPolicy, changed, nil))
+	require.NoError(t, err)
+	defer dst2.Close()
+	report, err = checkEquivalence(src, dst2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Mismatched)
+	assert.Contains(t, report.Examples[0], "2001:251::: ")
+
+	// the xdb files of different ip versions are not compared
+	v4, err := xdb.NewWithFileOnly(makeTestDb(t, xdb.VectorIndexPolicy, testLines, nil))
+	require.NoError(t, err)
+	defer v4.Close()
+	_, err = checkEquivalence(v4, dst, 0)
+	assert.ErrorIs(t, err, xdb.ErrIPVersionMismatch)
+}
+
+func TestUpstreamRegion(t *testing.T) {
+	var layout = fieldLayout()
+	assert.Equal(t, "中国|0|广东省|深圳市|电信", upstreamRegion("中国|广东省|深圳市|电信", layout))
+	assert.Equal(t, "中国|0|北京|北京市|教育网|AS4538", upstreamRegion("中国|北京|北京市|教育网|AS4538", layout))
+
+	// the layout with the area is kept as it is
+	var withArea = []string{xdb.REGION_FIELD_COUNTRY, xdb.REGION_FIELD_AREA, xdb.REGION_FIELD_PROVINCE, xdb.REGION_FIELD_CITY, xdb.REGION_FIELD_ISP}
+	assert.Equal(t, "中国|华南|广东省|深圳市|电信", upstreamRegion("中国|华南|广东省|深圳市|电信", withArea))
+	assert.Equal(t, "中国|广东省", upstreamRegion("中国|广东省", nil))
+
+	// the area is compared only if both of them have it
+	assert.True(t, sameRegion(xdb.ParseRegion("中国|0|广东省|深圳市|电信", withArea), xdb.ParseRegion("中国|广东省|深圳市|电信", layout)))
+	assert.False(t, sameRegion(xdb.ParseRegion("中国|广东省|深圳市|电信|AS4134", layout), xdb.ParseRegion("中国|广东省|深圳市|电信", layout)))
+}
@@ -6,9 +6,12 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,12 +25,18 @@ func printHelp() {
 	fmt.Printf("  gen      generate the binary db file\n")
 	fmt.Printf("  search   binary xdb search test\n")
 	fmt.Printf("  bench    binary xdb bench test\n")
+	fmt.Printf("  ranges   find all the ip ranges of a region\n")
+	fmt.Printf("  verify   check the structure of the binary xdb\n")
+	fmt.Printf("  convert  convert between the original ip2region xdb and the igr xdb\n")
 }
 
 func genDb() {
 	var err error
 	var srcFile, dstFile = "", ""
+	var dataVersion, description = "", ""
+	var allowGaps = false
 	var indexPolicy = xdb.VectorIndexPolicy
+	var createdAt = ""
 	for i := 2; i < len(os.Args); i++ {
 		r := os.Args[i]
 		if len(r) < 5 {
@@ -49,12 +58,28 @@ func genDb() {
 			srcFile = r[sIdx+1:]
 		case "dst":
 			dstFile = r[sIdx+1:]
+		case "data-version":
+			dataVersion = r[sIdx+1:]
+		case "description":
+			description = r[sIdx+1:]
+		case "allow-gaps":
+			v := r[sIdx+1:]
+			if v == "true" || v == "1" {
+				allowGaps = true
+			} else if v == "false" || v == "0" {
+				allowGaps = false
+			} else {
+				fmt.Printf("invalid value for allow-gaps option, could be false/0 or true/1\n")
+				return
+			}
 		case "index":
 			indexPolicy, err = IndexPolicyFromString(r[sIdx+1:])
 			if err != nil {
 				fmt.Printf("parse policy: %s", err.Error())
 				return
 			}
+		case "created-at":
+			createdAt = r[sIdx+1:]
 		default:
 			fmt.Printf("undefine option `%s`\n", r)
 			return
@@ -66,6 +91,11 @@ func genDb() {
 		fmt.Printf("options:\n")
 		fmt.Printf(" --src string    source ip text file path\n")
 		fmt.Printf(" --dst string    destination binary xdb file path\n")
+		fmt.Printf(" --data-version string    release version of the data recorded in the xdb\n")
+		fmt.Printf(" --description string     description of the data recorded in the xdb\n")
+		fmt.Printf(" --allow-gaps bool        allow the segments not to cover the whole ip space\n")
+		fmt.Printf(" --index string           segment index policy, vector or btree, default vector\n")
+		fmt.Printf(" --created-at string      build time recorded in the xdb, unix timestamp or RFC3339, default $SOURCE_DATE_EPOCH or now\n")
 		return
 	}
 
@@ -76,10 +106,23 @@ func genDb() {
 		fmt.Printf("failed to create %s\n", err)
 		return
 	}
+	maker.SetDataVersion(dataVersion)
+	maker.SetDescription(description)
+	maker.SetAllowGaps(allowGaps)
+	buildTime, err := ResolveCreatedAt(createdAt, tStart)
+	if err != nil {
+		fmt.Printf("parse created-at: %s\n", err)
+		return
+	}
+	maker.SetCreatedAt(buildTime)
 
 	err = maker.Init()
 	if err != nil {
 		fmt.Printf("failed Init: %s\n", err)
+		var gap *xdb.DiscontinuityError
+		if errors.As(err, &gap) {
+			fmt.Printf("the segments should be sorted by ip and contiguous, check line %d of `%s`, or use --allow-gaps=true for a partial xdb\n", gap.Line, srcFile)
+		}
 		return
 	}
 
@@ -145,6 +188,7 @@ func testSearch(fullSearch bool) {
 	fmt.Println(`ip2region xdb search test program, commands:
 loadIndex : load the vector index for search speedup.
 clearIndex: clear the vector index.
+info      : print the build info of the xdb.
 quit      : exit the test program`)
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -171,27 +215,44 @@ quit      : exit the test program`)
 			searcher.ClearVectorIndex()
 			fmt.Printf("vector index cleared\n")
 			continue
+		} else if line == "info" {
+			info, err := searcher.Info()
+			if err != nil {
+				fmt.Printf("\x1b[0;31m{Err:%s}\x1b[0m\n", err.Error())
+				continue
+			}
+			printInfo(info)
+			continue
 		} else if line == "quit" {
 			break
 		}
 
-		ip, err := xdb.CheckIP(line)
-		if err != nil {
-			fmt.Printf("invalid ip address `%s`\n", line)
-			continue
-		}
-
 		tStart := time.Now()
-		region, err := searcher.SetSearchMode(fullSearch).Search(ip)
+		searcher.SetSearchMode(fullSearch)
+		// show the matched range as well
+		r, err := searcher.SearchRangeByStr(line)
 		ioCount := searcher.GetIOCount()
 		if err != nil {
 			fmt.Printf("\x1b[0;31m{Err:%s, iocount:%d}\x1b[0m\n", err.Error(), ioCount)
 		} else {
-			fmt.Printf("\x1b[0;32m{Region:%s, iocount:%d, took:%s}\x1b[0m\n", region, ioCount, time.Since(tStart))
+			fmt.Printf("\x1b[0;32m{Region:%s, range:%s-%s, cidr:%v, iocount:%d, took:%s}\x1b[0m\n",
+				r.Region, r.StartAddr, r.EndAddr, r.Prefixes(), ioCount, time.Since(tStart))
 		}
 	}
 }
 
+func printInfo(info *xdb.Info) {
+	fmt.Printf("version: %d, format: %s, index policy: %s, ip version: %s, created at: %s, size: %d, partial: %v\n",
+		info.Version, info.Format, info.IndexPolicy, info.IPVersion, info.CreatedAt.Format(time.RFC3339), info.Size, info.Partial)
+	fmt.Printf("field layout: %s\n", strings.Join(info.FieldLayout, xdb.REGION_STR_SEP))
+	if meta := info.Metadata; meta != nil {
+		fmt.Printf("data version: %s, description: %s\n", meta.DataVersion, meta.Description)
+		fmt.Printf("source: %s, sha256: %s\n", meta.SourceFile, meta.SourceSHA256)
+		fmt.Printf("segments: %d, heads: %d, tails: %d, maker version: %s\n",
+			meta.SegmentCount, meta.HeadCount, meta.TailCount, meta.MakerVersion)
+	}
+}
+
 func testBench() {
 	var err error
 	var dbFile, srcFile = "", ""
@@ -263,30 +324,51 @@ func testBench() {
 	var spentTime, ioCount int64
 	for scanner.Scan() {
 		var l = strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\n"))
-		seg, err := SegmentFrom(l)
-		if err != nil {
-			fmt.Println(err)
-			return
+		var ipList []string
+		var regionStr string
+		if isIPv6Line(l) {
+			seg, err := Segment6From(l)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			mip := MidIPv6(seg.StartIP, seg.EndIP)
+			for _, ip := range [][16]byte{seg.StartIP, MidIPv6(seg.StartIP, mip), mip, MidIPv6(mip, seg.EndIP), seg.EndIP} {
+				ipList = append(ipList, xdb.IPv6ToString(ip))
+			}
+			regionStr = seg.RegionStr()
+		} else {
+			seg, err := SegmentFrom(l)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			mip := xdb.MidIP(seg.StartIP, seg.EndIP)
+			for _, ip := range []uint32{seg.StartIP, xdb.MidIP(seg.StartIP, mip), mip, xdb.MidIP(mip, seg.EndIP), seg.EndIP} {
+				ipList = append(ipList, xdb.Long2IP(ip))
+			}
+			regionStr = seg.RegionStr()
 		}
 
 		fmt.Printf("try to bench segment: `%s`\n", l)
-		mip := xdb.MidIP(seg.StartIP, seg.EndIP)
-		for _, ip := range []uint32{seg.StartIP, xdb.MidIP(seg.StartIP, mip), mip, xdb.MidIP(mip, seg.EndIP), seg.EndIP} {
-			fmt.Printf("|-try to bench ip '%s' ... ", xdb.Long2IP(ip))
+		for _, ip := range ipList {
+			fmt.Printf("|-try to bench ip '%s' ... ", ip)
 			searchStart := time.Now()
-			region, err := searcher.Search(ip)
+			region, ioc, err := searcher.SearchByStrWithIOCount(ip)
 			spentTime += time.Since(searchStart).Microseconds()
-			ioCount += int64(searcher.GetIOCount())
+			ioCount += int64(ioc)
 			if err != nil {
-				fmt.Printf("failed to search ip '%s': %s\n", xdb.Long2IP(ip), err)
+				fmt.Printf("failed to search ip '%s': %s\n", ip, err)
 				return
 			}
 
 			// check the region info
 			count++
-			if region != seg.RegionStr() {
+			if region != regionStr {
 				errCount++
-				fmt.Printf(" --[Failed] (%s != %s)\n", region, seg.RegionStr())
+				fmt.Printf(" --[Failed] (%s != %s)\n", region, regionStr)
 				if !ignoreError {
 					return
 				}
@@ -300,6 +382,315 @@ func testBench() {
 	fmt.Printf("Bench finished, {count: %d, failed: %d, took: %s, avg io count: %.2f, avg search time: %.6f μs}\n", count, errCount, time.Since(tStart), float64(ioCount)/countFloat, float64(spentTime)/countFloat)
 }
 
+func findRanges() {
+	var dbFile = ""
+	var cidrOnly = false
+	var filter xdb.RegionFilter
+	for i := 2; i < len(os.Args); i++ {
+		r := os.Args[i]
+		if len(r) < 5 {
+			continue
+		}
+
+		if strings.Index(r, "--") != 0 {
+			continue
+		}
+
+		var sIdx = strings.Index(r, "=")
+		if sIdx < 0 {
+			fmt.Printf("missing = for args pair '%s'\n", r)
+			return
+		}
+
+		var v = r[sIdx+1:]
+		switch r[2:sIdx] {
+		case "db":
+			dbFile = v
+		case "country":
+			filter.Country = v
+		case "area":
+			filter.Area = v
+		case "province":
+			filter.Province = v
+		case "city":
+			filter.City = v
+		case "isp":
+			filter.ISP = v
+		case "head":
+			filter.Head = v
+		case "tail":
+			filter.Tail = v
+		case "region":
+			filter.Region = v
+		case "cidr":
+			if v == "true" || v == "1" {
+				cidrOnly = true
+			} else if v == "false" || v == "0" {
+				cidrOnly = false
+			} else {
+				fmt.Printf("invalid value for cidr option, could be false/0 or true/1\n")
+				return
+			}
+		default:
+			fmt.Printf("undefined option '%s'\n", r)
+			return
+		}
+	}
+
+	if dbFile == "" || filter.IsEmpty() {
+		fmt.Printf("%s ranges [command options]\n", os.Args[0])
+		fmt.Printf("options:\n")
+		fmt.Printf(" --db string          ip2region binary xdb file path\n")
+		fmt.Printf(" --country string     match the country field\n")
+		fmt.Printf(" --area string        match the area field\n")
+		fmt.Printf(" --province string    match the province field\n")
+		fmt.Printf(" --city string        match the city field\n")
+		fmt.Printf(" --isp string         match the isp field\n")
+		fmt.Printf(" --head string        match the full region head\n")
+		fmt.Printf(" --tail string        match the full region tail\n")
+		fmt.Printf(" --region string      match the full region string\n")
+		fmt.Printf(" --cidr bool          print the merged CIDR blocks only\n")
+		return
+	}
+
+	searcher, err := xdb.NewWithFileOnly(dbFile)
+	if err != nil {
+		fmt.Printf("failed to create searcher with `%s`: %s\n", dbFile, err)
+		return
+	}
+	defer searcher.Close()
+
+	ranges, err := searcher.SearchRangesByRegion(&filter)
+	if err != nil {
+		fmt.Printf("failed to find ranges: %s\n", err)
+		return
+	}
+
+	if cidrOnly {
+		for _, prefix := range xdb.RangesToPrefixes(ranges) {
+			fmt.Println(prefix)
+		}
+		return
+	}
+
+	for _, r := range ranges {
+		fmt.Printf("%s %v\n", r.String(), r.Prefixes())
+	}
+
+	// the ip count of the ipv6 ranges is beyond the uint64
+	if searcher.GetHeader().IPVersion == xdb.IPv6 {
+		fmt.Printf("Found %d ranges\n", len(ranges))
+		return
+	}
+
+	var total uint64
+	for _, r := range ranges {
+		total += uint64(r.EndIP-r.StartIP) + 1
+	}
+	fmt.Printf("Found %d ranges, %d ips\n", len(ranges), total)
+}
+
+// verifyDb check the xdb file and exit with 1 on any problem
+func verifyDb() {
+	var dbFile = ""
+	for i := 2; i < len(os.Args); i++ {
+		r := os.Args[i]
+		if len(r) < 5 {
+			continue
+		}
+
+		if strings.Index(r, "--") != 0 {
+			continue
+		}
+
+		var sIdx = strings.Index(r, "=")
+		if sIdx < 0 {
+			fmt.Printf("missing = for args pair '%s'\n", r)
+			os.Exit(2)
+		}
+
+		switch r[2:sIdx] {
+		case "db":
+			dbFile = r[sIdx+1:]
+		default:
+			fmt.Printf("undefined option '%s'\n", r)
+			os.Exit(2)
+		}
+	}
+
+	if dbFile == "" {
+		fmt.Printf("%s verify [command options]\n", os.Args[0])
+		fmt.Printf("options:\n")
+		fmt.Printf(" --db string    ip2region binary xdb file path\n")
+		os.Exit(2)
+	}
+
+	tStart := time.Now()
+	searcher, err := xdb.NewWithFileOnly(dbFile)
+	if err != nil {
+		fmt.Printf("failed to create searcher with `%s`: %s\n", dbFile, err)
+		os.Exit(1)
+	}
+	defer searcher.Close()
+
+	report, err := searcher.Check()
+	if err != nil {
+		fmt.Printf("failed to check `%s`: %s\n", dbFile, err)
+		os.Exit(1)
+	}
+
+	header := searcher.GetHeader()
+	fmt.Printf("xdb: %s, format: %s, ip version: %s, index policy: %s, checksum: %v\n", dbFile, header.Format, header.IPVersion, header.IndexPolicy, header.HasChecksum())
+	fmt.Printf("vector slots: %d, index entries: %d, region heads: %d, region tails: %d\n", report.Slots, report.Entries, report.Heads, report.Tails)
+	for _, problem := range report.Problems {
+		fmt.Printf("\x1b[0;31m|-%s\x1b[0m\n", problem)
+	}
+	if report.ProblemCount > len(report.Problems) {
+		fmt.Printf("\x1b[0;31m|-... and %d more\x1b[0m\n", report.ProblemCount-len(report.Problems))
+	}
+
+	if !report.OK() {
+		fmt.Printf("Verify failed, {problems: %d, took: %s}\n", report.ProblemCount, time.Since(tStart))
+		searcher.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Verify passed, {took: %s}\n", time.Since(tStart))
+}
+
+func convertDb() {
+	var err error
+	var srcFile, dstFile = "", ""
+	var dataVersion, description = "", ""
+	var samples = 100000
+	var indexPolicy = xdb.VectorIndexPolicy
+	var createdAt = ""
+	for i := 2; i < len(os.Args); i++ {
+		r := os.Args[i]
+		if len(r) < 5 {
+			continue
+		}
+
+		if strings.Index(r, "--") != 0 {
+			continue
+		}
+
+		var sIdx = strings.Index(r, "=")
+		if sIdx < 0 {
+			fmt.Printf("missing = for args pair '%s'\n", r)
+			os.Exit(2)
+		}
+
+		switch r[2:sIdx] {
+		case "src":
+			srcFile = r[sIdx+1:]
+		case "dst":
+			dstFile = r[sIdx+1:]
+		case "data-version":
+			dataVersion = r[sIdx+1:]
+		case "description":
+			description = r[sIdx+1:]
+		case "index":
+			indexPolicy, err = IndexPolicyFromString(r[sIdx+1:])
+			if err != nil {
+				fmt.Printf("parse policy: %s\n", err.Error())
+				os.Exit(2)
+			}
+		case "created-at":
+			createdAt = r[sIdx+1:]
+		case "samples":
+			samples, err = strconv.Atoi(r[sIdx+1:])
+			if err != nil || samples < 0 {
+				fmt.Printf("invalid samples `%s`\n", r[sIdx+1:])
+				os.Exit(2)
+			}
+		default:
+			fmt.Printf("undefined option '%s'\n", r)
+			os.Exit(2)
+		}
+	}
+
+	if srcFile == "" || dstFile == "" {
+		fmt.Printf("%s convert [command options]\n", os.Args[0])
+		fmt.Printf("options:\n")
+		fmt.Printf(" --src string    source xdb file path, the original ip2region xdb or the igr xdb\n")
+		fmt.Printf(" --dst string    destination xdb file path, in the other format of the source\n")
+		fmt.Printf(" --index string           segment index policy of the igr xdb, vector or btree, default vector\n")
+		fmt.Printf(" --data-version string    release version of the data recorded in the igr xdb\n")
+		fmt.Printf(" --description string     description of the data recorded in the igr xdb\n")
+		fmt.Printf(" --samples int            random ips compared after the conversion, default 100000\n")
+		fmt.Printf(" --created-at string      build time recorded in the xdb, default $SOURCE_DATE_EPOCH or the one of the source xdb\n")
+		os.Exit(2)
+	}
+
+	tStart := time.Now()
+	src, err := xdb.Create(srcFile, xdb.CACHE_POLICY_MEMORY)
+	if err != nil {
+		fmt.Printf("failed to create searcher with `%s`: %s\n", srcFile, err)
+		os.Exit(1)
+	}
+	defer src.Close()
+
+	// keep the build time of the source xdb, so the same source converts to the same xdb
+	buildTime, err := ResolveCreatedAt(createdAt, time.Unix(int64(src.GetHeader().CreatedAt), 0))
+	if err != nil {
+		fmt.Printf("parse created-at: %s\n", err)
+		os.Exit(2)
+	}
+
+	var format = src.GetHeader().Format
+	if format == xdb.UpstreamFormat {
+		fmt.Printf("convert %s xdb `%s` to %s xdb `%s` ... \n", format, srcFile, xdb.IGRFormat, dstFile)
+		if description == "" {
+			description = fmt.Sprintf("converted from the %s xdb", format)
+		}
+		err = makeFromSegments(src, filepath.Base(srcFile), dstFile, indexPolicy, func(maker *Maker) {
+			maker.SetDataVersion(dataVersion)
+			maker.SetDescription(description)
+			maker.SetCreatedAt(buildTime)
+		})
+		if err != nil {
+			fmt.Printf("failed to convert: %s\n", err)
+			os.Exit(1)
+		}
+	} else {
+		fmt.Printf("convert %s xdb `%s` to %s xdb `%s` ... \n", format, srcFile, xdb.UpstreamFormat, dstFile)
+		count, err := writeUpstream(src, dstFile, buildTime)
+		if err != nil {
+			fmt.Printf("failed to convert: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d segments written\n", count)
+	}
+
+	// compare the regions of both the xdb files
+	dst, err := xdb.Create(dstFile, xdb.CACHE_POLICY_MEMORY)
+	if err != nil {
+		fmt.Printf("failed to create searcher with `%s`: %s\n", dstFile, err)
+		os.Exit(1)
+	}
+	defer dst.Close()
+
+	report, err := checkEquivalence(src, dst, samples)
+	if err != nil {
+		fmt.Printf("failed to check the equivalence: %s\n", err)
+		os.Exit(1)
+	}
+
+	for _, example := range report.Examples {
+		fmt.Printf("\x1b[0;31m|-%s\x1b[0m\n", example)
+	}
+
+	if report.Mismatched > 0 {
+		fmt.Printf("Convert failed, {checked: %d, mismatched: %d, took: %s}\n", report.Checked, report.Mismatched, time.Since(tStart))
+		src.Close()
+		dst.Close()
+		os.Exit(1)
+	}
+
+	fmt.Printf("Convert done, {checked: %d, mismatched: 0, took: %s}\n", report.Checked, time.Since(tStart))
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		printHelp()
@@ -319,6 +710,12 @@ func main() {
 		testSearch(false)
 	case "bench":
 		testBench()
+	case "ranges":
+		findRanges()
+	case "verify":
+		verifyDb()
+	case "convert":
+		convertDb()
 	default:
 		printHelp()
 	}
@@ -17,10 +17,18 @@
 // -- 4bytes: generate unix timestamp (version)
 // -- 4bytes: index block start ptr
 // -- 4bytes: index block end ptr
+// -- 4bytes: region head block start ptr
+// -- 2bytes: ip version, 4 for ipv4 and 6 for ipv6
+// -- 2bytes: flags, 0x1 for the checksums recorded
+// -- 4bytes: metadata section ptr
+// -- 4bytes: metadata section length
+// -- header[32:48]: crc32 checksums of the header, vector index, region block and segment index block
+// -- header[64:128]: region field layout, 1byte length + field names joined with `|`
 //
 //
 // 2. data block : region or whatever data info.
 // 3. segment index block : binary index block.
+// 3.1 metadata block : json encoded build info, eg: data version, source file sha256.
 // 4. vector index block  : fixed index info for block index search speed up.
 // space structure table:
 // -- 0   -> | 1rt super block | 2nd super block | 3rd super block | ... | 255th super block
@@ -41,27 +49,63 @@
 // |		2bytes		| 		2 Bytes		| 		4 Bytes			|
 // +--------------------+-------------------+-----------------------+
 // 	start ip tail			end ip tail			region(tail) ptr
+//
+// ipv6 segment index structure, the vector index is located by the first two bytes
+// of the address as well, and the ip tails are stored in big endian
+// +--------------------+-------------------+-----------------------+
+// |		14 Bytes	| 		14 Bytes	| 		4 Bytes			|
+// +--------------------+-------------------+-----------------------+
+// 	start ip tail			end ip tail			region(tail) ptr
 
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/arnoluo/ip-go-region/xdb"
 )
 
+// MakerVersion is recorded in the metadata of the xdb made
+const MakerVersion = "2.1.0"
+
+// maxSourceLineLength is the longest valid source line `sip|eip|country|area|province|tail`:
+// two ipv6 addresses, and the head, the dropped area and the tail of REGION_MAX_LENGTH bytes each
+const maxSourceLineLength = 2*39 + 3*xdb.REGION_MAX_LENGTH + 5
+
 type Maker struct {
-	srcHandle *os.File
+	// source lines of the segments, a file or any other stream
+	srcHandle io.ReadCloser
 	dstHandle *os.File
 
+	// build info written into the metadata block
+	metadata xdb.Metadata
+	// build time recorded in the header, see SetCreatedAt
+	createdAt time.Time
+
 	indexPolicy xdb.IndexPolicy
-	segments    []*Segment
+	// ip version of the source file, detected with the first segment
+	ipVersion xdb.IPVersion
+	// allow the gaps between the segments, and if the segments do not cover the whole ip space
+	allowGaps bool
+	partial   bool
+	segments  []*Segment
+	segments6 []*Segment6
 	// regionPool  map[string]uint32
 	vectorIndex []byte
 	region      *region
@@ -74,6 +118,18 @@ func NewMaker(policy xdb.IndexPolicy, srcFile string, dstFile string) (*Maker, e
 		return nil, fmt.Errorf("open source file `%s`: %w", srcFile, err)
 	}
 
+	maker, err := NewMakerWithReader(policy, srcHandle, filepath.Base(srcFile), dstFile)
+	if err != nil {
+		_ = srcHandle.Close()
+		return nil, err
+	}
+
+	return maker, nil
+}
+
+// NewMakerWithReader create a maker reading the source lines from the src,
+// the srcName is recorded in the metadata as the source file, and the src is closed by End
+func NewMakerWithReader(policy xdb.IndexPolicy, src io.ReadCloser, srcName string, dstFile string) (*Maker, error) {
 	// open the destination file with Read/Write mode
 	dstHandle, err := os.OpenFile(dstFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
@@ -81,11 +137,19 @@ func NewMaker(policy xdb.IndexPolicy, srcFile string, dstFile string) (*Maker, e
 	}
 
 	return &Maker{
-		srcHandle: srcHandle,
+		srcHandle: src,
 		dstHandle: dstHandle,
+		metadata: xdb.Metadata{
+			SourceFile:   srcName,
+			FieldLayout:  fieldLayout(),
+			MakerVersion: MakerVersion,
+		},
 
+		createdAt:   time.Now(),
 		indexPolicy: policy,
+		ipVersion:   xdb.IPv4,
 		segments:    []*Segment{},
+		segments6:   []*Segment6{},
 		// regionPool:  map[string]uint32{},
 		vectorIndex: make([]byte, xdb.VectorIndexLength),
 		region: &region{
@@ -98,6 +162,28 @@ func NewMaker(policy xdb.IndexPolicy, srcFile string, dstFile string) (*Maker, e
 	}, nil
 }
 
+// SetDataVersion set the release version of the data recorded in the metadata
+func (m *Maker) SetDataVersion(version string) {
+	m.metadata.DataVersion = version
+}
+
+// SetAllowGaps allow the source segments not to cover the whole ip space,
+// they should be still sorted without overlapping, and the ips in the gaps are searched as not found
+func (m *Maker) SetAllowGaps(allow bool) {
+	m.allowGaps = allow
+}
+
+// SetCreatedAt set the build time recorded in the header instead of the current time,
+// the same source with the same build time makes the byte-identical xdb
+func (m *Maker) SetCreatedAt(createdAt time.Time) {
+	m.createdAt = createdAt
+}
+
+// SetDescription set the description of the data recorded in the metadata
+func (m *Maker) SetDescription(description string) {
+	m.metadata.Description = description
+}
+
 func (m *Maker) initDbHeader() error {
 	log.Printf("try to init the db header ... ")
 
@@ -116,7 +202,7 @@ func (m *Maker) initDbHeader() error {
 	binary.LittleEndian.PutUint16(header[2:], uint16(m.indexPolicy))
 
 	// 3, generate unix timestamp
-	binary.LittleEndian.PutUint32(header[4:], uint32(time.Now().Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(m.createdAt.Unix()))
 
 	// 4, index block start ptr
 	binary.LittleEndian.PutUint32(header[8:], uint32(0))
@@ -127,6 +213,14 @@ func (m *Maker) initDbHeader() error {
 	// 6, index region head start ptr
 	binary.LittleEndian.PutUint32(header[16:], uint32(0))
 
+	// 7, region field layout
+	layout := strings.Join(fieldLayout(), xdb.REGION_STR_SEP)
+	if len(layout) >= xdb.HeaderFieldLayoutLength {
+		return fmt.Errorf("too long field layout `%s`", layout)
+	}
+	header[xdb.HeaderFieldLayoutOffset] = uint8(len(layout))
+	copy(header[xdb.HeaderFieldLayoutOffset+1:], layout)
+
 	_, err = m.dstHandle.Write(header)
 	if err != nil {
 		return err
@@ -138,33 +232,99 @@ func (m *Maker) initDbHeader() error {
 func (m *Maker) loadSegments() error {
 	log.Printf("try to load the segments ... ")
 	var last *Segment = nil
+	var last6 *Segment6 = nil
 	var tStart = time.Now()
 
-	var scanner = bufio.NewScanner(m.srcHandle)
+	// the source file is hashed while scanning
+	var hash = sha256.New()
+	var scanner = bufio.NewScanner(io.TeeReader(m.srcHandle, hash))
 	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
+	// the longer line is refused with its line number below
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSourceLineLength)
+	var lineNum = 0
+	for ; scanner.Scan(); lineNum++ {
 		var l = strings.TrimSpace(strings.TrimSuffix(scanner.Text(), "\n"))
 		log.Printf("load segment: `%s`", l)
 
+		// the first line decides the ip version of the db
+		if lineNum == 0 && isIPv6Line(l) {
+			m.ipVersion = xdb.IPv6
+		}
+
+		if m.ipVersion == xdb.IPv6 {
+			seg, err := Segment6From(l)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", lineNum+1, err)
+			}
+
+			// check the continuity of the data segment
+			if last6 == nil {
+				m.partial = seg.StartIP != [16]byte{}
+			} else if next := nextIPv6(last6.EndIP); next != seg.StartIP {
+				if !m.allowGaps || next == [16]byte{} || bytes.Compare(seg.StartIP[:], next[:]) < 0 {
+					return &xdb.DiscontinuityError{
+						Line:      lineNum + 1,
+						LastEndIP: netip.AddrFrom16(last6.EndIP),
+						StartIP:   netip.AddrFrom16(seg.StartIP),
+					}
+				}
+				m.partial = true
+			}
+
+			m.region.seed(seg.RegionHead, seg.RegionTail)
+			m.segments6 = append(m.segments6, seg)
+			last6 = seg
+			continue
+		}
+
+		if isIPv6Line(l) {
+			return fmt.Errorf("line %d: %w: ipv6 segment `%s` in an ipv4 source file", lineNum+1, xdb.ErrIPVersionMismatch, l)
+		}
+
 		seg, err := SegmentFrom(l)
 		if err != nil {
-			return err
+			return fmt.Errorf("line %d: %w", lineNum+1, err)
 		}
 
 		m.region.seed(seg.RegionHead, seg.RegionTail)
 
 		// check the continuity of the data segment
-		if last != nil {
-			if last.EndIP+1 != seg.StartIP {
-				return fmt.Errorf("discontinuous data segment: last.eip+1(%d) != seg.sip(%d, %s)", last.EndIP, seg.StartIP, xdb.Long2IP(seg.StartIP))
+		if last == nil {
+			m.partial = seg.StartIP != 0
+		} else if last.EndIP+1 != seg.StartIP {
+			if !m.allowGaps || last.EndIP == 0xFFFFFFFF || seg.StartIP < last.EndIP+1 {
+				return &xdb.DiscontinuityError{
+					Line:      lineNum + 1,
+					LastEndIP: xdb.Long2Addr(last.EndIP),
+					StartIP:   xdb.Long2Addr(seg.StartIP),
+				}
 			}
+			m.partial = true
 		}
 
 		m.segments = append(m.segments, seg)
 		last = seg
 	}
 
-	log.Printf("all segments loaded, length: %d, elapsed: %s", len(m.segments), time.Since(tStart))
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d: %w: longer than %d bytes, the region fields should be less than or equal %d bytes each",
+				lineNum+1, xdb.ErrInvalidSegment, maxSourceLineLength, xdb.REGION_MAX_LENGTH)
+		}
+		return fmt.Errorf("scan source file: %w", err)
+	}
+
+	// the segments should end with the max ip to cover the whole ip space
+	if last != nil && last.EndIP != 0xFFFFFFFF {
+		m.partial = true
+	}
+	if last6 != nil && nextIPv6(last6.EndIP) != [16]byte{} {
+		m.partial = true
+	}
+
+	m.metadata.SourceSHA256 = hex.EncodeToString(hash.Sum(nil))
+	m.metadata.SegmentCount = len(m.segments) + len(m.segments6)
+	log.Printf("all segments loaded, ip version: %s, length: %d, elapsed: %s", m.ipVersion, len(m.segments)+len(m.segments6), time.Since(tStart))
 	return nil
 }
 
@@ -187,15 +347,22 @@ func (m *Maker) Init() error {
 
 // refresh the vector index of the specified ip
 func (m *Maker) setVectorIndex(ip uint32, ptr uint32) {
-	var il0 = (ip >> 24) & 0xFF
-	var il1 = (ip >> 16) & 0xFF
+	m.setVectorIndexAt((ip>>24)&0xFF, (ip>>16)&0xFF, ptr, xdb.RegionIndexBlockSize)
+}
+
+// refresh the vector index of the specified ipv6 address
+func (m *Maker) setVectorIndexV6(ip [16]byte, ptr uint32) {
+	m.setVectorIndexAt(uint32(ip[0]), uint32(ip[1]), ptr, xdb.IPv6RegionIndexBlockSize)
+}
+
+func (m *Maker) setVectorIndexAt(il0, il1 uint32, ptr uint32, blockSize uint32) {
 	var idx = il0*xdb.VectorIndexCols*xdb.VectorIndexSize + il1*xdb.VectorIndexSize
 	var sPtr = binary.LittleEndian.Uint32(m.vectorIndex[idx:])
 	if sPtr == 0 {
 		binary.LittleEndian.PutUint32(m.vectorIndex[idx:], ptr)
-		binary.LittleEndian.PutUint32(m.vectorIndex[idx+4:], ptr+xdb.RegionIndexBlockSize)
+		binary.LittleEndian.PutUint32(m.vectorIndex[idx+4:], ptr+blockSize)
 	} else {
-		binary.LittleEndian.PutUint32(m.vectorIndex[idx+4:], ptr+xdb.RegionIndexBlockSize)
+		binary.LittleEndian.PutUint32(m.vectorIndex[idx+4:], ptr+blockSize)
 	}
 }
 
@@ -217,7 +384,7 @@ func (m *Maker) setReserveIndex() (pos uint32, err error) {
 	}
 
 	if m.region.reservedTailPtr == 0 {
-		err = fmt.Errorf("reserved tail ptr not set")
+		err = fmt.Errorf("reserve region info not set")
 		return
 	}
 
@@ -231,9 +398,45 @@ func (m *Maker) setReserveIndex() (pos uint32, err error) {
 	return
 }
 
+// ParseCreatedAt parse the build time of the unix timestamp or the RFC3339 time string,
+// eg: the value of the SOURCE_DATE_EPOCH environment variable
+func ParseCreatedAt(str string) (time.Time, error) {
+	var createdAt time.Time
+	if ts, err := strconv.ParseInt(str, 10, 64); err == nil {
+		createdAt = time.Unix(ts, 0)
+	} else {
+		createdAt, err = time.Parse(time.RFC3339, str)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid build time `%s`, should be a unix timestamp or RFC3339 time", str)
+		}
+	}
+
+	// the header records the unix timestamp in 4 bytes
+	if createdAt.Unix() < 0 || createdAt.Unix() > math.MaxUint32 {
+		return time.Time{}, fmt.Errorf("build time `%s` out of range", str)
+	}
+
+	return createdAt, nil
+}
+
+// ResolveCreatedAt return the build time of the created-at option, or the one of the
+// SOURCE_DATE_EPOCH environment variable for the reproducible builds if the option is empty,
+// the fallback is returned if both of them are empty
+func ResolveCreatedAt(option string, fallback time.Time) (time.Time, error) {
+	if option == "" {
+		option = os.Getenv("SOURCE_DATE_EPOCH")
+	}
+
+	if option == "" {
+		return fallback, nil
+	}
+
+	return ParseCreatedAt(option)
+}
+
 // Start to make the binary file
 func (m *Maker) Start() error {
-	if len(m.segments) < 1 {
+	if len(m.segments) < 1 && len(m.segments6) < 1 {
 		return fmt.Errorf("empty segment list")
 	}
 
@@ -244,41 +447,184 @@ func (m *Maker) Start() error {
 	}
 
 	log.Printf("try to write the region tree block ... ")
-	if m.region.write(m.dstHandle) != nil {
-		return fmt.Errorf("seek to current ptr: %s", err)
+	err = m.region.write(m.dstHandle)
+	if err != nil {
+		return fmt.Errorf("write region tree block: %w", err)
 	}
 
-	reservedTailPtr, err := m.setReserveIndex()
+	// 2, write the index block and cache the super index block
+	log.Printf("try to write the segment index block ... ")
+	var counter int
+	var startIndexPtr, endIndexPtr int64
+	if m.indexPolicy == xdb.BTreeIndexPolicy {
+		counter, startIndexPtr, endIndexPtr, err = m.writeSegmentIndexBTree()
+	} else if m.ipVersion == xdb.IPv6 {
+		counter, startIndexPtr, endIndexPtr, err = m.writeSegmentIndexV6()
+	} else {
+		counter, startIndexPtr, endIndexPtr, err = m.writeSegmentIndex()
+	}
 	if err != nil {
 		return err
 	}
 
-	// 2, write the index block and cache the super index block
-	log.Printf("try to write the segment index block ... ")
+	// write the metadata block behind the segment index
+	log.Printf("try to write the metadata block ... ")
+	metadataPtr, metadataLen, err := m.writeMetadata()
+	if err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+
+	// synchronized the vector index block
+	log.Printf("try to write the vector index block ... ")
+	_, err = m.dstHandle.Seek(int64(xdb.HeaderInfoLength), 0)
+	if err != nil {
+		return fmt.Errorf("seek vector index first ptr: %w", err)
+	}
+	_, err = m.dstHandle.Write(m.vectorIndex)
+	if err != nil {
+		return fmt.Errorf("write vector index: %w", err)
+	}
+
+	// synchronized the segment index info
+	// head info
+	var headerBuff = make([]byte, 24)
+	log.Printf("try to write the segment index ptr ... ")
+	binary.LittleEndian.PutUint32(headerBuff, uint32(startIndexPtr))
+	binary.LittleEndian.PutUint32(headerBuff[4:], uint32(endIndexPtr))
+	binary.LittleEndian.PutUint32(headerBuff[8:], m.region.startPtr)
+	binary.LittleEndian.PutUint16(headerBuff[12:], uint16(m.ipVersion))
+	var flags = xdb.HeaderFlagChecksum
+	if m.partial {
+		flags |= xdb.HeaderFlagPartial
+	}
+	if m.region.wideHeadOffset {
+		flags |= xdb.HeaderFlagWideHeadOffset
+	}
+	if m.region.varintLength {
+		flags |= xdb.HeaderFlagVarintLength
+	}
+	binary.LittleEndian.PutUint16(headerBuff[14:], flags)
+	binary.LittleEndian.PutUint32(headerBuff[16:], metadataPtr)
+	binary.LittleEndian.PutUint32(headerBuff[20:], metadataLen)
+	_, err = m.dstHandle.Seek(8, 0)
+	if err != nil {
+		return fmt.Errorf("seek segment index ptr: %w", err)
+	}
+
+	_, err = m.dstHandle.Write(headerBuff)
+	if err != nil {
+		return fmt.Errorf("write segment index ptr: %w", err)
+	}
+
+	// the region record extensions are rejected by the searchers not knowing the extended version
+	if m.region.wideHeadOffset || m.region.varintLength {
+		var version = binary.LittleEndian.AppendUint16(nil, xdb.VersionExtended)
+		_, err = m.dstHandle.WriteAt(version, 0)
+		if err != nil {
+			return fmt.Errorf("write the extended version: %w", err)
+		}
+	}
+
+	// checksums at last, after all the other data written
+	log.Printf("try to write the checksums ... ")
+	err = m.writeChecksums()
+	if err != nil {
+		return fmt.Errorf("write checksums: %w", err)
+	}
+
+	log.Printf("write done, regionBlocks: (head: %d, tail: %d), indexBlocks: %d, indexPtr: (start: %d, end: %d)",
+		m.region.totalTree, m.region.totalTail, counter, startIndexPtr, endIndexPtr)
+
+	return nil
+}
+
+// compute and write the checksums of the sections, then the checksum of the header
+func (m *Maker) writeChecksums() error {
+	fi, err := m.dstHandle.Stat()
+	if err != nil {
+		return err
+	}
+
+	checksums, err := xdb.ComputeChecksums(m.dstHandle, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	var buff = make([]byte, 12)
+	binary.LittleEndian.PutUint32(buff, checksums.VectorIndex)
+	binary.LittleEndian.PutUint32(buff[4:], checksums.Region)
+	binary.LittleEndian.PutUint32(buff[8:], checksums.SegmentIndex)
+	_, err = m.dstHandle.WriteAt(buff, xdb.HeaderChecksumOffset+4)
+	if err != nil {
+		return err
+	}
+
+	var header = make([]byte, xdb.HeaderInfoLength)
+	_, err = m.dstHandle.ReadAt(header, 0)
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(buff, xdb.HeaderChecksum(header))
+	_, err = m.dstHandle.WriteAt(buff[:4], xdb.HeaderChecksumOffset)
+	return err
+}
+
+// write the json encoded metadata block at the current ptr
+func (m *Maker) writeMetadata() (ptr uint32, length uint32, err error) {
+	m.metadata.HeadCount = m.region.totalTree
+	m.metadata.TailCount = m.region.totalTail
+	buff, err := json.Marshal(m.metadata)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pos, err := m.dstHandle.Seek(0, 1)
+	if err != nil {
+		return 0, 0, fmt.Errorf("seek to metadata block: %w", err)
+	}
+
+	_, err = m.dstHandle.Write(buff)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint32(pos), uint32(len(buff)), nil
+}
+
+// write the ipv4 segment index block and refresh the vector index
+func (m *Maker) writeSegmentIndex() (counter int, startIndexPtr int64, endIndexPtr int64, err error) {
+	// the reserved index block is the first one of the segment index block,
+	// it is written only if the reserved region is in the source file
+	var reservedIndexPtr uint32
+	startIndexPtr, endIndexPtr = int64(-1), int64(-1)
+	if m.region.reservedTailPtr != 0 {
+		reservedIndexPtr, err = m.setReserveIndex()
+		if err != nil {
+			return
+		}
+		counter, startIndexPtr, endIndexPtr = 1, int64(reservedIndexPtr), int64(reservedIndexPtr)
+	}
+
 	var indexBuff = make([]byte, xdb.RegionIndexBlockSize)
-	var counter, startIndexPtr, endIndexPtr = 1, int64(-1), int64(-1)
 	for _, seg := range m.segments {
 		// dataPtr, has := m.regionPool[seg.Region]
 		// headStr, tailStr := rgn.headAndTail(seg.Region)
-		regionTree, has := m.region.treeMap[seg.RegionHead]
-		if !has {
-			return fmt.Errorf("missing ptr cache for head `%s`", seg.RegionHead)
-		}
-		tailPtr, has := regionTree.tailPtrMap[seg.RegionTail]
-		if !has {
-			return fmt.Errorf("missing ptr cache for tail `%s`", seg.RegionTail)
+		tailPtr, err := m.region.tailPtr(seg.RegionHead, seg.RegionTail)
+		if err != nil {
+			return counter, startIndexPtr, endIndexPtr, err
 		}
 
-		isReserved := seg.IsReserved()
+		isReserved := reservedIndexPtr != 0 && seg.IsReserved()
 		var segList = seg.Split()
 		log.Printf("try to index segment(startIp:%s) splits...", xdb.Long2IP(seg.StartIP))
 		for _, s := range segList {
 			pos, err := m.dstHandle.Seek(0, 1)
 			if err != nil {
-				return fmt.Errorf("seek to segment index block: %w", err)
+				return counter, startIndexPtr, endIndexPtr, fmt.Errorf("seek to segment index block: %w", err)
 			}
 			if isReserved {
-				m.setReservedVectorIndex(s.StartIP, reservedTailPtr)
+				m.setReservedVectorIndex(s.StartIP, reservedIndexPtr)
 			} else {
 				// encode the segment index
 				binary.LittleEndian.PutUint16(indexBuff, uint16(s.StartIP&xdb.IP_TAIL_PATTERN))
@@ -287,13 +633,54 @@ func (m *Maker) Start() error {
 				binary.LittleEndian.PutUint32(indexBuff[4:], tailPtr)
 				_, err = m.dstHandle.Write(indexBuff)
 				if err != nil {
-					return fmt.Errorf("write segment index for '%s': %w", s.String(), err)
+					return counter, startIndexPtr, endIndexPtr, fmt.Errorf("write segment index for '%s': %w", s.String(), err)
 				}
 
 				// log.Printf("|-segment index: %d, ptr: %d, segment: %s\n", counter, pos, s.String())
 				m.setVectorIndex(s.StartIP, uint32(pos))
 				counter++
+
+				// check and record the start index ptr
+				if startIndexPtr == -1 {
+					startIndexPtr = pos
+				}
+
+				endIndexPtr = pos
 			}
+		}
+	}
+
+	return
+}
+
+// write the ipv6 segment index block and refresh the vector index
+func (m *Maker) writeSegmentIndexV6() (counter int, startIndexPtr int64, endIndexPtr int64, err error) {
+	var indexBuff = make([]byte, xdb.IPv6RegionIndexBlockSize)
+	startIndexPtr, endIndexPtr = int64(-1), int64(-1)
+	for _, seg := range m.segments6 {
+		tailPtr, err := m.region.tailPtr(seg.RegionHead, seg.RegionTail)
+		if err != nil {
+			return counter, startIndexPtr, endIndexPtr, err
+		}
+
+		log.Printf("try to index segment(startIp:%s) splits...", xdb.IPv6ToString(seg.StartIP))
+		for _, s := range seg.Split() {
+			pos, err := m.dstHandle.Seek(0, 1)
+			if err != nil {
+				return counter, startIndexPtr, endIndexPtr, fmt.Errorf("seek to segment index block: %w", err)
+			}
+
+			// encode the segment index
+			copy(indexBuff, s.StartIP[2:])
+			copy(indexBuff[xdb.IPv6TailLength:], s.EndIP[2:])
+			binary.LittleEndian.PutUint32(indexBuff[xdb.IPv6TailLength*2:], tailPtr)
+			_, err = m.dstHandle.Write(indexBuff)
+			if err != nil {
+				return counter, startIndexPtr, endIndexPtr, fmt.Errorf("write segment index for '%s': %w", s.String(), err)
+			}
+
+			m.setVectorIndexV6(s.StartIP, uint32(pos))
+			counter++
 
 			// check and record the start index ptr
 			if startIndexPtr == -1 {
@@ -304,38 +691,70 @@ func (m *Maker) Start() error {
 		}
 	}
 
-	// synchronized the vector index block
-	log.Printf("try to write the vector index block ... ")
-	_, err = m.dstHandle.Seek(int64(xdb.HeaderInfoLength), 0)
-	if err != nil {
-		return fmt.Errorf("seek vector index first ptr: %w", err)
-	}
-	_, err = m.dstHandle.Write(m.vectorIndex)
-	if err != nil {
-		return fmt.Errorf("write vector index: %w", err)
+	return
+}
+
+// write the btree segment index block, one block for every segment without any split,
+// and refresh the vector index of all the slots the segment overlaps
+func (m *Maker) writeSegmentIndexBTree() (counter int, startIndexPtr int64, endIndexPtr int64, err error) {
+	var blockSize = uint32(xdb.BTreeRegionIndexBlockSize)
+	if m.ipVersion == xdb.IPv6 {
+		blockSize = xdb.IPv6BTreeRegionIndexBlockSize
 	}
 
-	// synchronized the segment index info
-	// head info
-	var headerBuff = make([]byte, 12)
-	log.Printf("try to write the segment index ptr ... ")
-	binary.LittleEndian.PutUint32(headerBuff, uint32(startIndexPtr))
-	binary.LittleEndian.PutUint32(headerBuff[4:], uint32(endIndexPtr))
-	binary.LittleEndian.PutUint32(headerBuff[8:], m.region.startPtr)
-	_, err = m.dstHandle.Seek(8, 0)
-	if err != nil {
-		return fmt.Errorf("seek segment index ptr: %w", err)
+	var indexBuff = make([]byte, blockSize)
+	var write = func(head, tail string, sSlot, eSlot uint32, desc string) error {
+		tailPtr, err := m.region.tailPtr(head, tail)
+		if err != nil {
+			return err
+		}
+
+		pos, err := m.dstHandle.Seek(0, 1)
+		if err != nil {
+			return fmt.Errorf("seek to segment index block: %w", err)
+		}
+
+		binary.LittleEndian.PutUint32(indexBuff[blockSize-4:], tailPtr)
+		_, err = m.dstHandle.Write(indexBuff)
+		if err != nil {
+			return fmt.Errorf("write segment index for '%s': %w", desc, err)
+		}
+
+		for slot := sSlot; slot <= eSlot; slot++ {
+			m.setVectorIndexAt(slot>>8, slot&0xFF, uint32(pos), blockSize)
+		}
+
+		counter++
+		if startIndexPtr == -1 {
+			startIndexPtr = pos
+		}
+
+		endIndexPtr = pos
+		return nil
 	}
 
-	_, err = m.dstHandle.Write(headerBuff)
-	if err != nil {
-		return fmt.Errorf("write segment index ptr: %w", err)
+	startIndexPtr, endIndexPtr = int64(-1), int64(-1)
+	for _, seg := range m.segments {
+		binary.LittleEndian.PutUint32(indexBuff, seg.StartIP)
+		binary.LittleEndian.PutUint32(indexBuff[4:], seg.EndIP)
+		err = write(seg.RegionHead, seg.RegionTail, seg.StartIP>>16, seg.EndIP>>16, seg.String())
+		if err != nil {
+			return
+		}
 	}
 
-	log.Printf("write done, regionBlocks: (head: %d, tail: %d), indexBlocks: %d, indexPtr: (start: %d, end: %d)",
-		m.region.totalTree, m.region.totalTail, counter, startIndexPtr, endIndexPtr)
+	for _, seg := range m.segments6 {
+		copy(indexBuff, seg.StartIP[:])
+		copy(indexBuff[16:], seg.EndIP[:])
+		var sSlot = uint32(binary.BigEndian.Uint16(seg.StartIP[:]))
+		var eSlot = uint32(binary.BigEndian.Uint16(seg.EndIP[:]))
+		err = write(seg.RegionHead, seg.RegionTail, sSlot, eSlot, seg.String())
+		if err != nil {
+			return
+		}
+	}
 
-	return nil
+	return
 }
 
 func (m *Maker) End() error {
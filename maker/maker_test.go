@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testCreatedAt = time.Unix(1666666666, 0)
+
+// the source lines of the maker, the area field is dropped from the region head
+var testLines = []string{
+	"0.0.0.0|1.0.0.255|中国|0|广东省|深圳市|电信",
+	"1.0.1.0|1.0.3.255|中国|0|广东省|广州市|联通",
+	"1.0.4.0|2.12.133.255|美国|0|0|0|0",
+	"2.12.134.0|2.12.139.255|法国|0|Ille-et-Vilaine|0|橘子电信",
+	"2.12.140.0|2.13.0.255|中国|0|广东省|深圳市|移动",
+	"2.13.1.0|9.255.255.255|美国|0|0|0|0",
+	"10.0.0.0|10.255.255.255|0|0|0|内网IP|内网IP",
+	"11.0.0.0|255.255.255.255|中国|0|北京|北京市|教育网|AS4538|Asia/Shanghai",
+}
+
+func TestMain(m *testing.M) {
+	// the maker logs every region written
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// makeTestDb make the xdb of the source lines into a temp file with the fixed build time,
+// the setup is applied to the maker before the build
+func makeTestDb(t *testing.T, policy xdb.IndexPolicy, lines []string, setup func(*Maker)) string {
+	t.Helper()
+
+	var dstFile = filepath.Join(t.TempDir(), "test.xdb")
+	var src = io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	maker, err := NewMakerWithReader(policy, src, "test.txt", dstFile)
+	require.NoError(t, err)
+	maker.SetCreatedAt(testCreatedAt)
+	if setup != nil {
+		setup(maker)
+	}
+
+	require.NoError(t, maker.Init())
+	require.NoError(t, maker.Start())
+	require.NoError(t, maker.End())
+	return dstFile
+}
+
+// readSegments return the segment strings of the xdb file
+func readSegments(t *testing.T, dbFile string) []string {
+	t.Helper()
+
+	searcher, err := xdb.NewWithFileOnly(dbFile)
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	var segments []string
+	it := searcher.NewSegmentIterator()
+	for it.Next() {
+		segments = append(segments, it.Segment().String())
+	}
+	require.NoError(t, it.Err())
+	return segments
+}
+
+// igrSegment return the segment string of the source line without the area field
+func igrSegment(line string) string {
+	pieces := strings.SplitN(line, xdb.REGION_STR_SEP, 5)
+	return strings.Join(append(pieces[:3], pieces[4]), xdb.REGION_STR_SEP)
+}
+
+// checkTestDb check the structure of the xdb and search the start and end ip of the source lines
+func checkTestDb(t *testing.T, dbFile string, lines []string) {
+	t.Helper()
+
+	searcher, err := xdb.NewWithFileOnly(dbFile)
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	report, err := searcher.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Problems)
+
+	for _, line := range lines {
+		var expect = igrSegment(line)
+		pieces := strings.SplitN(expect, xdb.REGION_STR_SEP, 3)
+		for _, ip := range pieces[:2] {
+			region, err := searcher.SearchByStr(ip)
+			require.NoError(t, err, ip)
+			require.Equal(t, pieces[2], region, ip)
+		}
+	}
+}
+
+func TestMakerSearch(t *testing.T) {
+	for _, policy := range []xdb.IndexPolicy{xdb.VectorIndexPolicy, xdb.BTreeIndexPolicy} {
+		dbFile := makeTestDb(t, policy, testLines, nil)
+		checkTestDb(t, dbFile, testLines)
+
+		header, err := xdb.LoadHeaderFromFile(dbFile)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(xdb.VersionNo), header.Version)
+		assert.Equal(t, policy, header.IndexPolicy)
+		assert.Equal(t, uint32(testCreatedAt.Unix()), header.CreatedAt)
+		assert.False(t, header.WideHeadOffset())
+		assert.False(t, header.VarintLength())
+	}
+}
+
+func TestMakerReproducible(t *testing.T) {
+	for _, policy := range []xdb.IndexPolicy{xdb.VectorIndexPolicy, xdb.BTreeIndexPolicy} {
+		first, err := os.ReadFile(makeTestDb(t, policy, testLines, nil))
+		require.NoError(t, err)
+
+		// the map iteration order differs between the builds
+		for i := 0; i < 5; i++ {
+			buff, err := os.ReadFile(makeTestDb(t, policy, testLines, nil))
+			require.NoError(t, err)
+			require.Equal(t, first, buff, "build %d", i)
+		}
+	}
+}
+
+func TestMakerRegionOrder(t *testing.T) {
+	dbFile := makeTestDb(t, xdb.VectorIndexPolicy, testLines, nil)
+	buff, err := os.ReadFile(dbFile)
+	require.NoError(t, err)
+	header, err := xdb.LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+
+	// the heads in the order of the first seen, then the tails grouped by the heads
+	var expectHeads = []string{"中国|广东省", "美国|0", "法国|Ille-et-Vilaine", "0|0", "中国|北京"}
+	var expectTails = []string{"深圳市|电信", "广州市|联通", "深圳市|移动", "0|0", "0|橘子电信", "内网IP|内网IP", "北京市|教育网|AS4538|Asia/Shanghai"}
+	var ptr = int(header.RegionHeadStartPtr)
+	var heads, tails []string
+	for range expectHeads {
+		heads = append(heads, string(buff[ptr+1:ptr+1+int(buff[ptr])]))
+		ptr += 1 + int(buff[ptr])
+	}
+	for range expectTails {
+		var length = int(buff[ptr+2])
+		tails = append(tails, string(buff[ptr+3:ptr+3+length]))
+		ptr += 3 + length
+	}
+
+	assert.Equal(t, expectHeads, heads)
+	assert.Equal(t, expectTails, tails)
+}
+
+func TestParseCreatedAt(t *testing.T) {
+	for str, expect := range map[string]int64{
+		"0":                         0,
+		"1666666666":                1666666666,
+		"4294967295":                4294967295,
+		"2022-10-25T02:57:46Z":      1666666666,
+		"2022-10-25T10:57:46+08:00": 1666666666,
+	} {
+		createdAt, err := ParseCreatedAt(str)
+		require.NoError(t, err, str)
+		assert.Equal(t, expect, createdAt.Unix(), str)
+	}
+
+	for _, str := range []string{"", "now", "-1", "4294967296", "2022-10-25", "1666666666.5"} {
+		_, err := ParseCreatedAt(str)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestResolveCreatedAt(t *testing.T) {
+	var fallback = time.Unix(1234, 0)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	createdAt, err := ResolveCreatedAt("", fallback)
+	require.NoError(t, err)
+	assert.Equal(t, fallback, createdAt)
+
+	// the environment variable is used without the option
+	t.Setenv("SOURCE_DATE_EPOCH", "1666666666")
+	createdAt, err = ResolveCreatedAt("", fallback)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1666666666), createdAt.Unix())
+
+	// the option overrides the environment variable
+	createdAt, err = ResolveCreatedAt("2022-10-25T02:57:47Z", fallback)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1666666667), createdAt.Unix())
+
+	t.Setenv("SOURCE_DATE_EPOCH", "invalid")
+	_, err = ResolveCreatedAt("", fallback)
+	assert.Error(t, err)
+	_, err = ResolveCreatedAt("-5", fallback)
+	assert.Error(t, err)
+}
@@ -3,23 +3,27 @@
 
 // region head:
 // the blocks start ptr(4Bytes) saved in header[16:]
-// Marked head location with a 2B offset value in tail block
+// Marked head location with a 2B offset value in tail block,
+// or a 4B one if the head blocks exceed 64 KiB, see xdb.HeaderFlagWideHeadOffset
 // block structure:
 // +----------------+-------------------+
 // |	1 Byte		|		n Bytes		|
 // +----------------+-------------------+
 // 	byte len(<64)		head string bytes
+// the 1 byte length is replaced with the uvarint one if any head or tail exceeds the length limits,
+// see xdb.HeaderFlagVarintLength, the length is still 1 byte for the strings shorter than 128 bytes
 //
 
 // region tail:
-// first 2B for region head offset, 1 addition io to get head data
-// then 1B for tail str byte num
-// last n Bytes for tail str(n <= 255)
+// first 2B(4B with the wide head offset) for region head offset, 1 addition io to get head data
+// then 1B(1-3B with the varint length) for tail str byte num
+// last n Bytes for tail str(n <= 255, or n <= 65535 with the varint length)
 // block structure:
 // +--------------------+-----------------------+-------------------+
-// |		2 Bytes		|		1 Byte			|		n Bytes		|
+// |	2 or 4 Bytes	|	1 or 1-3 Bytes		|		n Bytes		|
 // +--------------------+-----------------------+-------------------+
-// 	head offset(<=65535)	 tail byte num			tail string bytes(n <= 255)
+// 	head offset			 tail byte num			tail string bytes
+// 	(<=65535, or <=2^32-1 wide)	(n <= 255, or n <= 65535 varint)
 //
 
 package main
@@ -28,6 +32,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"strings"
 
@@ -50,23 +55,42 @@ const RESERVED_HEAD_ADDR = "0|0"
 type region struct {
 	startPtr uint32
 	// head string => head info & tails
-	treeMap         map[string]*regionTree
+	treeMap map[string]*regionTree
+	// the heads in the order of the first seeding, so the same source makes the same xdb
+	heads           []string
 	totalTail       int
 	totalTree       int
 	reservedTailPtr uint32
+	// the head offsets are saved in 4 bytes, since the head blocks exceed 64 KiB
+	wideHeadOffset bool
+	// the heads and tails are prefixed with the uvarint length, since some of them are too long
+	varintLength bool
 }
 
 type regionTree struct {
-	headOffset uint16
+	headOffset uint32
 	// tail string => tail info ptr
 	tailPtrMap map[string]uint32
+	// the tails in the order of the first seeding
+	tails []string
 }
 
 func headAndTail(region string) (head string, tail string, err error) {
 	pieces := strings.SplitN(region, xdb.REGION_STR_SEP, 4)
+	if len(pieces) < 4 {
+		err = fmt.Errorf("%w: region info `%s` should have at least 4 fields", xdb.ErrInvalidSegment, region)
+		return
+	}
+
 	if REGION_HEAD_TYPE == REGION_HEAD_TYPE_ALL {
 		head = strings.Join(pieces[:3], xdb.REGION_STR_SEP)
 	} else {
+		// the dropped area is limited as the other fields, so the source line is bounded
+		if len(pieces[1]) > xdb.REGION_MAX_LENGTH {
+			err = fmt.Errorf("%w: too long region area `%s`(%dB): should be less than or equal %d bytes", xdb.ErrInvalidSegment, pieces[1], len(pieces[1]), xdb.REGION_MAX_LENGTH)
+			return
+		}
+
 		head = strings.Join([]string{
 			pieces[0],
 			pieces[2],
@@ -75,28 +99,49 @@ func headAndTail(region string) (head string, tail string, err error) {
 
 	tail = pieces[3]
 	err = nil
-	if err = checkRegionTail(head); err == nil {
+	if err = checkRegionHead(head); err == nil {
 		err = checkRegionTail(tail)
 	}
 	return
 }
 
+// fieldLayout return the field names of the region string saved in the xdb
+// with the REGION_HEAD_TYPE, the custom extension fields of the tail are not included
+func fieldLayout() []string {
+	if REGION_HEAD_TYPE == REGION_HEAD_TYPE_ALL {
+		return []string{xdb.REGION_FIELD_COUNTRY, xdb.REGION_FIELD_AREA, xdb.REGION_FIELD_PROVINCE, xdb.REGION_FIELD_CITY, xdb.REGION_FIELD_ISP}
+	}
+
+	return []string{xdb.REGION_FIELD_COUNTRY, xdb.REGION_FIELD_PROVINCE, xdb.REGION_FIELD_CITY, xdb.REGION_FIELD_ISP}
+}
+
+// the heads of REGION_BASE_BLOCK_SIZE bytes or more and the tails over 255 bytes
+// are saved with the varint length, see region.write
 func checkRegionHead(regionHead string) (err error) {
 	err = nil
-	if len(regionHead) >= xdb.REGION_BASE_BLOCK_SIZE {
-		err = fmt.Errorf("too long region info `%s`(%dB): should be less than %d bytes", regionHead, len(regionHead), xdb.REGION_BASE_BLOCK_SIZE)
+	if len(regionHead) > xdb.REGION_MAX_LENGTH {
+		err = fmt.Errorf("%w: too long region info `%s`(%dB): should be less than or equal %d bytes", xdb.ErrInvalidSegment, regionHead, len(regionHead), xdb.REGION_MAX_LENGTH)
 	}
 	return
 }
 
 func checkRegionTail(regionTail string) (err error) {
 	err = nil
-	if len(regionTail) > 0xFF {
-		err = fmt.Errorf("too long region tail info `%s`(%dB): should be less than or equal %d bytes", regionTail, len(regionTail), 0xFF)
+	if len(regionTail) > xdb.REGION_MAX_LENGTH {
+		err = fmt.Errorf("%w: too long region tail info `%s`(%dB): should be less than or equal %d bytes", xdb.ErrInvalidSegment, regionTail, len(regionTail), xdb.REGION_MAX_LENGTH)
 	}
 	return
 }
 
+// appendLength append the length prefix of the head or tail string
+func (r *region) appendLength(dst []byte, str string) []byte {
+	if r.varintLength {
+		return binary.AppendUvarint(dst, uint64(len(str)))
+	}
+
+	return append(dst, uint8(len(str)))
+}
+
 func (r *region) seed(headStr string, tailStr string) {
 	// headStr, tailStr, err = headAndTail(region)
 	// if err != nil {
@@ -109,6 +154,7 @@ func (r *region) seed(headStr string, tailStr string) {
 		_, hasKey := tree.tailPtrMap[tailStr]
 		if !hasKey {
 			tree.tailPtrMap[tailStr] = 0
+			tree.tails = append(tree.tails, tailStr)
 		}
 	} else {
 		r.treeMap[headStr] = &regionTree{
@@ -116,7 +162,9 @@ func (r *region) seed(headStr string, tailStr string) {
 			tailPtrMap: map[string]uint32{
 				tailStr: 0,
 			},
+			tails: []string{tailStr},
 		}
+		r.heads = append(r.heads, headStr)
 	}
 }
 
@@ -126,35 +174,67 @@ func (r *region) write(dstHandle *os.File) error {
 		return fmt.Errorf("seek to current ptr: %s", err)
 	}
 	r.startPtr = uint32(pos)
-	var offset uint16
-	for regionHead, tree := range r.treeMap {
+
+	// the 1 byte length is kept unless any head or tail is too long for it
+	r.varintLength = false
+	for _, regionHead := range r.heads {
+		if len(regionHead) >= xdb.REGION_BASE_BLOCK_SIZE {
+			r.varintLength = true
+		}
+		for _, tailStr := range r.treeMap[regionHead].tails {
+			if len(tailStr) > 0xFF {
+				r.varintLength = true
+			}
+		}
+	}
+	if r.varintLength {
+		log.Printf("region heads or tails exceed the 1 byte length limits, use the varint length")
+	}
+
+	// the head offsets exceeding the 2 bytes are only known after all the heads sized
+	var headBlockSize int64
+	for _, regionHead := range r.heads {
+		headBlockSize += int64(len(r.appendLength(nil, regionHead)) + len(regionHead))
+	}
+	if headBlockSize > math.MaxUint32 {
+		return fmt.Errorf("region head blocks of %d bytes overflowed", headBlockSize)
+	}
+	r.wideHeadOffset = headBlockSize > 0xFFFF
+	if r.wideHeadOffset {
+		log.Printf("region head blocks of %d bytes exceed 64 KiB, use the 4 bytes head offset", headBlockSize)
+	}
+
+	var offset uint32
+	for _, regionHead := range r.heads {
+		var tree = r.treeMap[regionHead]
 		tree.headOffset = offset
-		var headByteAll = make([]byte, 1)
-		headByteAll[0] = uint8(len(regionHead))
+		var headByteAll = r.appendLength(nil, regionHead)
 		headByteAll = append(headByteAll, []byte(regionHead)...)
 		writedByteNum, err := dstHandle.Write(headByteAll)
 		if err != nil {
 			return fmt.Errorf("write region '%s': %w", regionHead, err)
 		}
 
-		offset += uint16(writedByteNum)
-		if offset > 0xFFFF {
-			return fmt.Errorf("seek head offset overflowed: %d", offset)
-		}
+		offset += uint32(writedByteNum)
 	}
 	r.totalTree = len(r.treeMap)
 
-	for _, tree := range r.treeMap {
-		for tailStr := range tree.tailPtrMap {
+	for _, regionHead := range r.heads {
+		var tree = r.treeMap[regionHead]
+		for _, tailStr := range tree.tails {
 			tailPos, err := dstHandle.Seek(0, 1)
 			// _, err = dstHandle.Write(tailBody)
 			if err != nil {
 				return fmt.Errorf("write tail pos '%d': %w", pos, err)
 			}
 
-			var tailAll = make([]byte, 3)
-			binary.LittleEndian.PutUint16(tailAll, tree.headOffset)
-			tailAll[2] = uint8(len([]byte(tailStr)))
+			var tailAll []byte
+			if r.wideHeadOffset {
+				tailAll = binary.LittleEndian.AppendUint32(tailAll, tree.headOffset)
+			} else {
+				tailAll = binary.LittleEndian.AppendUint16(tailAll, uint16(tree.headOffset))
+			}
+			tailAll = r.appendLength(tailAll, tailStr)
 			tailAll = append(tailAll, []byte(tailStr)...)
 
 			_, err = dstHandle.Write(tailAll)
@@ -163,11 +243,12 @@ func (r *region) write(dstHandle *os.File) error {
 			}
 
 			tree.tailPtrMap[tailStr] = uint32(tailPos)
-			log.Printf(" --[Added] with ptr=%d", pos)
+			log.Printf(" --[Added] with ptr=%d", tailPos)
 		}
 		r.totalTail += len(tree.tailPtrMap)
 	}
 
+	// the reserved region is required by the ipv4 segment index only
 	reservedTree, has := r.treeMap[RESERVED_HEAD_ADDR]
 	if has {
 		reservedTail, hasTail := reservedTree.tailPtrMap[RESERVED_TAIL_ADDR]
@@ -175,9 +256,21 @@ func (r *region) write(dstHandle *os.File) error {
 			r.reservedTailPtr = reservedTail
 		}
 	}
-	if r.reservedTailPtr == 0 {
-		return fmt.Errorf("reserve region info not set")
-	}
 
 	return nil
 }
+
+// tailPtr return the written region tail ptr of the head and tail
+func (r *region) tailPtr(headStr string, tailStr string) (uint32, error) {
+	regionTree, has := r.treeMap[headStr]
+	if !has {
+		return 0, fmt.Errorf("missing ptr cache for head `%s`", headStr)
+	}
+
+	tailPtr, has := regionTree.tailPtrMap[tailStr]
+	if !has {
+		return 0, fmt.Errorf("missing ptr cache for tail `%s`", tailStr)
+	}
+
+	return tailPtr, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakerWideHeadOffset(t *testing.T) {
+	// the distinct heads exceed 64 KiB of the head block
+	var lines []string
+	const count, size = 4096, 1 << 20
+	for i := 0; i < count; i++ {
+		lines = append(lines, fmt.Sprintf("%s|%s|国家%04d|0|省份%04d|城市%d|0",
+			xdb.Long2IP(uint32(i*size)), xdb.Long2IP(uint32(i*size+size-1)), i, i, i%7))
+	}
+
+	for _, policy := range []xdb.IndexPolicy{xdb.VectorIndexPolicy, xdb.BTreeIndexPolicy} {
+		dbFile := makeTestDb(t, policy, lines, nil)
+		header, err := xdb.LoadHeaderFromFile(dbFile)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(xdb.VersionExtended), header.Version)
+		assert.True(t, header.WideHeadOffset())
+		assert.False(t, header.VarintLength())
+		checkTestDb(t, dbFile, lines)
+	}
+}
+
+func TestMakerVarintLength(t *testing.T) {
+	var lines = append([]string(nil), testLines...)
+	lines[1] = "1.0.1.0|1.0.3.255|法国|0|" + strings.Repeat("Ille-et-Vilaine", 6) + "|0|橘子电信"
+	lines[5] = "2.13.1.0|9.255.255.255|美国|0|0|0|0|" + strings.Repeat("AS3215|", 60) + "end"
+
+	for _, policy := range []xdb.IndexPolicy{xdb.VectorIndexPolicy, xdb.BTreeIndexPolicy} {
+		dbFile := makeTestDb(t, policy, lines, nil)
+		header, err := xdb.LoadHeaderFromFile(dbFile)
+		require.NoError(t, err)
+		assert.Equal(t, uint16(xdb.VersionExtended), header.Version)
+		assert.True(t, header.VarintLength())
+		assert.False(t, header.WideHeadOffset())
+		checkTestDb(t, dbFile, lines)
+
+		var expect []string
+		for _, line := range lines {
+			expect = append(expect, igrSegment(line))
+		}
+		assert.Equal(t, expect, readSegments(t, dbFile))
+	}
+
+	// the longest tail is accepted
+	lines[5] = "2.13.1.0|9.255.255.255|美国|0|0|0|" + strings.Repeat("0", xdb.REGION_MAX_LENGTH-2)
+	checkTestDb(t, makeTestDb(t, xdb.VectorIndexPolicy, lines, nil), lines)
+
+	// the too long tail is refused
+	lines[5] = "2.13.1.0|9.255.255.255|美国|0|0|0|" + strings.Repeat("0", xdb.REGION_MAX_LENGTH+1)
+	var src = io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	maker, err := NewMakerWithReader(xdb.VectorIndexPolicy, src, "test.txt", filepath.Join(t.TempDir(), "test.xdb"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, maker.Init(), xdb.ErrInvalidSegment)
+	require.NoError(t, maker.End())
+
+	// the short records of the partial xdb keep the original version
+	dbFile := makeTestDb(t, xdb.VectorIndexPolicy, lines[2:5], func(maker *Maker) {
+		maker.SetAllowGaps(true)
+	})
+	header, err := xdb.LoadHeaderFromFile(dbFile)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(xdb.VersionNo), header.Version)
+	assert.Equal(t, xdb.HeaderFlagChecksum|xdb.HeaderFlagPartial, header.Flags)
+}
+
+func TestMakerLongField(t *testing.T) {
+	var initErr = func(lines []string) error {
+		var src = io.NopCloser(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+		maker, err := NewMakerWithReader(xdb.VectorIndexPolicy, src, "test.txt", filepath.Join(t.TempDir(), "test.xdb"))
+		require.NoError(t, err)
+		defer maker.End()
+		return maker.Init()
+	}
+
+	// every field of the longest line is accepted, including the dropped area
+	var long = strings.Repeat("0", xdb.REGION_MAX_LENGTH)
+	var lines = append([]string(nil), testLines...)
+	lines[5] = "2.13.1.0|9.255.255.255|" + strings.Repeat("0", xdb.REGION_MAX_LENGTH-2) + "|" + long + "|0|" + long
+	require.NoError(t, initErr(lines))
+
+	// the too long area is refused with the line number
+	lines[5] = "2.13.1.0|9.255.255.255|美国|" + long + "0|0|0|0"
+	err := initErr(lines)
+	assert.ErrorIs(t, err, xdb.ErrInvalidSegment)
+	assert.Contains(t, err.Error(), "line 6:")
+
+	// the line longer than any valid one is refused before it is split
+	lines[5] = "2.13.1.0|9.255.255.255|美国|0|0|0|" + strings.Repeat(long+"|", 4)
+	err = initErr(lines)
+	assert.ErrorIs(t, err, xdb.ErrInvalidSegment)
+	assert.Contains(t, err.Error(), "line 6:")
+}
@@ -22,7 +22,7 @@ func SegmentFrom(lineStr string) (seg *Segment, err error) {
 
 	var ps = strings.SplitN(lineStr, "|", 3)
 	if len(ps) != 3 {
-		err = fmt.Errorf("invalid ip segment line `%s`", lineStr)
+		err = fmt.Errorf("%w: invalid ip segment line `%s`", xdb.ErrInvalidSegment, lineStr)
 		return
 	}
 
@@ -37,12 +37,12 @@ func SegmentFrom(lineStr string) (seg *Segment, err error) {
 	}
 
 	if sip > eip {
-		err = fmt.Errorf("start ip(%s) should not be greater than end ip(%s)", ps[0], ps[1])
+		err = fmt.Errorf("%w: start ip(%s) should not be greater than end ip(%s)", xdb.ErrInvalidSegment, ps[0], ps[1])
 		return
 	}
 
 	if len(ps[2]) < 1 {
-		err = fmt.Errorf("empty region info in segment line `%s`", lineStr)
+		err = fmt.Errorf("%w: empty region info in segment line `%s`", xdb.ErrInvalidSegment, lineStr)
 		return
 	}
 
@@ -0,0 +1,153 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/arnoluo/ip-go-region/xdb"
+)
+
+// Segment6 is the ipv6 version of Segment
+type Segment6 struct {
+	StartIP    [16]byte
+	EndIP      [16]byte
+	RegionHead string
+	RegionTail string
+}
+
+// isIPv6Line check if the start ip of the segment line is an ipv6 address
+func isIPv6Line(lineStr string) bool {
+	var sIdx = strings.Index(lineStr, "|")
+	if sIdx < 0 {
+		return false
+	}
+
+	return strings.Contains(lineStr[:sIdx], ":")
+}
+
+func Segment6From(lineStr string) (seg *Segment6, err error) {
+	var ps = strings.SplitN(lineStr, "|", 3)
+	if len(ps) != 3 {
+		err = fmt.Errorf("%w: invalid ip segment line `%s`", xdb.ErrInvalidSegment, lineStr)
+		return
+	}
+
+	sip, err := xdb.CheckIPv6(ps[0])
+	if err != nil {
+		return
+	}
+
+	eip, err := xdb.CheckIPv6(ps[1])
+	if err != nil {
+		return
+	}
+
+	if bytes.Compare(sip[:], eip[:]) > 0 {
+		err = fmt.Errorf("%w: start ip(%s) should not be greater than end ip(%s)", xdb.ErrInvalidSegment, ps[0], ps[1])
+		return
+	}
+
+	if len(ps[2]) < 1 {
+		err = fmt.Errorf("%w: empty region info in segment line `%s`", xdb.ErrInvalidSegment, lineStr)
+		return
+	}
+
+	regionHead, regionTail, err := headAndTail(ps[2])
+	if err != nil {
+		return
+	}
+
+	seg = &Segment6{
+		StartIP:    sip,
+		EndIP:      eip,
+		RegionHead: regionHead,
+		RegionTail: regionTail,
+	}
+	return
+}
+
+// Split the segment based on the pre-two bytes
+func (s *Segment6) Split() []*Segment6 {
+	var segList []*Segment6
+	var sPrefix = uint16(s.StartIP[0])<<8 | uint16(s.StartIP[1])
+	var ePrefix = uint16(s.EndIP[0])<<8 | uint16(s.EndIP[1])
+	for i := uint32(sPrefix); i <= uint32(ePrefix); i++ {
+		var sip, eip [16]byte
+		if i == uint32(sPrefix) {
+			sip = s.StartIP
+		} else {
+			sip[0], sip[1] = byte(i>>8), byte(i)
+		}
+
+		if i == uint32(ePrefix) {
+			eip = s.EndIP
+		} else {
+			eip[0], eip[1] = byte(i>>8), byte(i)
+			for j := 2; j < len(eip); j++ {
+				eip[j] = 0xFF
+			}
+		}
+
+		segList = append(segList, &Segment6{
+			StartIP:    sip,
+			EndIP:      eip,
+			RegionHead: s.RegionHead,
+			RegionTail: s.RegionTail,
+		})
+	}
+
+	return segList
+}
+
+func (s *Segment6) String() string {
+	return strings.Join([]string{
+		xdb.IPv6ToString(s.StartIP),
+		xdb.IPv6ToString(s.EndIP),
+		s.RegionHead,
+		s.RegionTail,
+	}, xdb.REGION_STR_SEP)
+}
+
+func (s *Segment6) RegionStr() string {
+	return strings.Join([]string{
+		s.RegionHead,
+		s.RegionTail,
+	}, xdb.REGION_STR_SEP)
+}
+
+// nextIPv6 return ip + 1, the max address wraps to zero
+func nextIPv6(ip [16]byte) [16]byte {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+
+	return ip
+}
+
+// MidIPv6 return the middle address of sip and eip
+func MidIPv6(sip [16]byte, eip [16]byte) [16]byte {
+	// sum = sip + eip with 129 bits, then shift it right by one bit
+	var sum [16]byte
+	var carry uint16
+	for i := len(sum) - 1; i >= 0; i-- {
+		v := uint16(sip[i]) + uint16(eip[i]) + carry
+		sum[i] = byte(v)
+		carry = v >> 8
+	}
+
+	var mid [16]byte
+	for i := 0; i < len(sum); i++ {
+		mid[i] = byte(carry<<7) | sum[i]>>1
+		carry = uint16(sum[i] & 1)
+	}
+
+	return mid
+}
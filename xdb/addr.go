@@ -0,0 +1,110 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// search with the net/netip.Addr and net.IP values.
+// the ipv4-mapped ipv6 addresses (::ffff:a.b.c.d) are unmapped to ipv4,
+// and the address family should match the ip version of the xdb.
+
+package xdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// UnsupportedAddrError is returned when the address is invalid
+// or its family is not supported by the xdb
+type UnsupportedAddrError struct {
+	Addr string
+	// ip version of the xdb
+	IPVersion IPVersion
+	// the address is malformed, eg: the zero netip.Addr or a net.IP of a wrong length
+	Invalid bool
+}
+
+func (e *UnsupportedAddrError) Error() string {
+	if e.Invalid {
+		return fmt.Sprintf("invalid address `%s` for the %s xdb", e.Addr, e.IPVersion)
+	}
+
+	return fmt.Sprintf("unsupported address `%s` for the %s xdb", e.Addr, e.IPVersion)
+}
+
+// Is match ErrInvalidIP for the invalid address, and ErrIPVersionMismatch for the others
+func (e *UnsupportedAddrError) Is(target error) bool {
+	if e.Invalid {
+		return target == ErrInvalidIP
+	}
+
+	return target == ErrIPVersionMismatch
+}
+
+// ParseRemoteAddr parse the address of a `host:port` string like http.Request.RemoteAddr,
+// a bare address without port is accepted as well
+func ParseRemoteAddr(remoteAddr string) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err == nil {
+		return addrPort.Addr(), nil
+	}
+
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: invalid remote address `%s`", ErrInvalidIP, remoteAddr)
+	}
+
+	return addr, nil
+}
+
+// SearchByAddr find the region for the specified netip.Addr
+func (s *Searcher) SearchByAddr(addr netip.Addr) (string, error) {
+	region, _, err := s.SearchByAddrWithIOCount(addr)
+	return region, err
+}
+
+// SearchByAddrWithIOCount find the region and the io count for the specified netip.Addr
+func (s *Searcher) SearchByAddrWithIOCount(addr netip.Addr) (string, int, error) {
+	addr = addr.Unmap().WithZone("")
+	switch {
+	case addr.Is4() && s.header.IPVersion == IPv4:
+		ip := addr.As4()
+		return s.SearchWithIOCount(binary.BigEndian.Uint32(ip[:]))
+	case addr.Is6() && s.header.IPVersion == IPv6:
+		return s.SearchV6WithIOCount(addr.As16())
+	default:
+		return "", 0, &UnsupportedAddrError{Addr: addr.String(), IPVersion: s.header.IPVersion, Invalid: !addr.IsValid()}
+	}
+}
+
+// SearchRegionByAddr find the structured region for the specified netip.Addr
+func (s *Searcher) SearchRegionByAddr(addr netip.Addr) (*Region, error) {
+	region, err := s.SearchByAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRegion(region, s.header.FieldLayout), nil
+}
+
+// SearchByNetIP find the region for the specified net.IP
+func (s *Searcher) SearchByNetIP(ip net.IP) (string, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", &UnsupportedAddrError{Addr: ip.String(), IPVersion: s.header.IPVersion, Invalid: true}
+	}
+
+	return s.SearchByAddr(addr)
+}
+
+// SearchRegionByNetIP find the structured region for the specified net.IP
+func (s *Searcher) SearchRegionByNetIP(ip net.IP) (*Region, error) {
+	region, err := s.SearchByNetIP(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRegion(region, s.header.FieldLayout), nil
+}
@@ -0,0 +1,90 @@
+package xdb
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchByAddr(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	for _, addr := range []netip.Addr{
+		netip.MustParseAddr("2.12.134.0"),
+		netip.MustParseAddr("::ffff:2.12.134.0"),
+	} {
+		region, err := searcher.SearchByAddr(addr)
+		require.NoError(t, err, addr)
+		assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region, addr)
+	}
+
+	for _, ip := range []net.IP{
+		net.ParseIP("2.12.134.0"),
+		net.ParseIP("2.12.134.0").To4(),
+	} {
+		region, err := searcher.SearchByNetIP(ip)
+		require.NoError(t, err, ip)
+		assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region, ip)
+	}
+
+	var addrErr *UnsupportedAddrError
+	_, err = searcher.SearchByAddr(netip.MustParseAddr("2001:251::"))
+	require.True(t, errors.As(err, &addrErr))
+	assert.Equal(t, IPv4, addrErr.IPVersion)
+	assert.ErrorIs(t, err, ErrIPVersionMismatch)
+	assert.NotErrorIs(t, err, ErrInvalidIP)
+
+	_, err = searcher.SearchByAddr(netip.Addr{})
+	assert.True(t, errors.As(err, &addrErr))
+	assert.ErrorIs(t, err, ErrInvalidIP)
+	assert.NotErrorIs(t, err, ErrIPVersionMismatch)
+
+	// the malformed net.IP values are invalid, not the version mismatched
+	for _, ip := range []net.IP{{1, 2, 3}, {}, nil, make(net.IP, 5)} {
+		_, err = searcher.SearchByNetIP(ip)
+		require.True(t, errors.As(err, &addrErr), "%v", ip)
+		assert.True(t, addrErr.Invalid, "%v", ip)
+		assert.ErrorIs(t, err, ErrInvalidIP, "%v", ip)
+		assert.NotErrorIs(t, err, ErrIPVersionMismatch, "%v", ip)
+	}
+}
+
+func TestSearchByAddrV6(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsV6))
+	require.NoError(t, err)
+
+	region, err := searcher.SearchByNetIP(net.ParseIP("2001:251::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "中国|北京|北京市|教育网", region)
+
+	region, err = searcher.SearchByAddr(netip.MustParseAddr("fe80::1%eth0"))
+	require.NoError(t, err)
+	assert.Equal(t, "日本|东京都|0|0", region)
+
+	var addrErr *UnsupportedAddrError
+	_, err = searcher.SearchByAddr(netip.MustParseAddr("::ffff:1.2.3.4"))
+	assert.True(t, errors.As(err, &addrErr))
+	assert.ErrorIs(t, err, ErrIPVersionMismatch)
+}
+
+func TestParseRemoteAddr(t *testing.T) {
+	for remoteAddr, expect := range map[string]string{
+		"1.2.3.4:8080":        "1.2.3.4",
+		"[2001:251::1]:443":   "2001:251::1",
+		"1.2.3.4":             "1.2.3.4",
+		"2001:251::1":         "2001:251::1",
+		"[::ffff:1.2.3.4]:80": "::ffff:1.2.3.4",
+	} {
+		addr, err := ParseRemoteAddr(remoteAddr)
+		require.NoError(t, err, remoteAddr)
+		assert.Equal(t, expect, addr.String())
+	}
+
+	_, err := ParseRemoteAddr("localhost:80")
+	assert.Error(t, err)
+}
@@ -0,0 +1,244 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// batch search for the ipv4 xdb.
+// the ips are searched in ascending order, so the segment index block of
+// a vector slot is loaded only once, and the region tails and heads are
+// loaded only once for all the ips in the batch.
+
+package xdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SearchBatchByStr find the regions for the specified ip strings,
+// the regions are returned in the same order as the input
+func (s *Searcher) SearchBatchByStr(ipList []string) ([]string, error) {
+	// the ipv6 strings are not parsed as the ipv4 ones
+	if s.header.IPVersion != IPv4 {
+		return nil, fmt.Errorf("%w: ipv4 search on a %s xdb", ErrIPVersionMismatch, s.header.IPVersion)
+	}
+
+	var ips = make([]uint32, len(ipList))
+	for i, str := range ipList {
+		ip, err := CheckIP(str)
+		if err != nil {
+			return nil, fmt.Errorf("check the %dth ip: %w", i, err)
+		}
+		ips[i] = ip
+	}
+
+	return s.SearchBatch(ips)
+}
+
+// SearchBatch find the regions for the specified long ips,
+// the regions are returned in the same order as the input,
+// and the region of the ips not covered by the xdb is an empty string
+func (s *Searcher) SearchBatch(ips []uint32) ([]string, error) {
+	regions, ioCount, err := s.SearchBatchWithIOCount(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ioCount.Store(int64(ioCount))
+	return regions, nil
+}
+
+// SearchBatchWithIOCount find the regions and the total io count for the specified long ips
+func (s *Searcher) SearchBatchWithIOCount(ips []uint32) ([]string, int, error) {
+	if s.header.IPVersion != IPv4 {
+		return nil, 0, fmt.Errorf("%w: ipv4 search on a %s xdb", ErrIPVersionMismatch, s.header.IPVersion)
+	}
+
+	var ioCount int
+	var b = &batch{
+		searcher: s,
+		ioCount:  &ioCount,
+		slot:     -1,
+		heads:    map[int64]string{},
+		regions:  map[int64]string{},
+	}
+
+	// search in ascending order of the ips
+	var order = make([]int, len(ips))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return ips[order[i]] < ips[order[j]]
+	})
+
+	var regions = make([]string, len(ips))
+	for i, idx := range order {
+		// same ip as the last one
+		if i > 0 && ips[idx] == ips[order[i-1]] {
+			regions[idx] = regions[order[i-1]]
+			continue
+		}
+
+		region, err := b.search(ips[idx])
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, ioCount, fmt.Errorf("search ip `%s`: %w", Long2IP(ips[idx]), err)
+		}
+		regions[idx] = region
+	}
+
+	return regions, ioCount, nil
+}
+
+type batch struct {
+	searcher *Searcher
+	ioCount  *int
+
+	// the vector slot and its segment index block loaded last time
+	slot  int64
+	block []byte
+
+	// the full segment located last time, for the btree and the original xdb
+	startIP   uint32
+	endIP     uint32
+	regionPtr int64
+
+	// region heads cached by head offset, and regions cached by region tail ptr
+	heads   map[int64]string
+	regions map[int64]string
+}
+
+func (b *batch) search(ip uint32) (string, error) {
+	if b.searcher.header.IndexPolicy == BTreeIndexPolicy || b.searcher.header.Format == UpstreamFormat {
+		return b.searchSegment(ip)
+	}
+
+	var slot = int64(ip >> 16)
+	if slot != b.slot {
+		err := b.loadBlock(ip)
+		if err != nil {
+			return "", err
+		}
+		b.slot = slot
+	}
+
+	// binary search the loaded segment index block
+	var regionPtr int64
+	var ipTail = uint16(ip & IP_TAIL_PATTERN)
+	var l, h = 0, len(b.block)/RegionIndexBlockSize - 1
+	for l <= h {
+		m := (l + h) >> 1
+		buff := b.block[m*RegionIndexBlockSize:]
+		if ipTail < binary.LittleEndian.Uint16(buff) {
+			h = m - 1
+		} else if ipTail > binary.LittleEndian.Uint16(buff[2:]) {
+			l = m + 1
+		} else {
+			regionPtr = int64(binary.LittleEndian.Uint32(buff[4:]))
+			break
+		}
+	}
+
+	if regionPtr == 0 {
+		return "", ErrNotFound
+	}
+
+	return b.region(regionPtr)
+}
+
+// searchSegment locate the full segment of the ip, the sorted ips in the same segment share one locating
+func (b *batch) searchSegment(ip uint32) (string, error) {
+	if b.regionPtr == 0 || ip < b.startIP || ip > b.endIP {
+		var lk = getLookup()
+		regionPtr, startIP, endIP, err := b.searcher.locate(ip, lk, b.ioCount)
+		putLookup(lk)
+		if err != nil {
+			return "", err
+		}
+		b.regionPtr, b.startIP, b.endIP = regionPtr, startIP, endIP
+	}
+
+	return b.region(b.regionPtr)
+}
+
+// loadBlock load the whole segment index block of the vector slot of the ip with one read
+func (b *batch) loadBlock(ip uint32) error {
+	var lk = getLookup()
+	sPtr, ePtr, err := b.searcher.vectorBlock((ip>>24)&0xFF, (ip>>16)&0xFF, lk, b.ioCount)
+	putLookup(lk)
+	if err != nil {
+		return err
+	}
+
+	// empty slot, not covered by the xdb
+	if sPtr == 0 && ePtr == 0 {
+		b.block = nil
+		return nil
+	}
+
+	// the ePtr is the end of the last index block, reserved slots share one index block
+	var length = ePtr - sPtr
+	if length < RegionIndexBlockSize {
+		length = RegionIndexBlockSize
+	}
+
+	// no copy for the content buffer
+	if cBuff := b.searcher.contentBuff; cBuff != nil && int64(sPtr)+int64(length) <= int64(len(cBuff)) {
+		b.block = cBuff[sPtr : sPtr+length]
+		return nil
+	}
+
+	var block = make([]byte, length)
+	err = b.searcher.read(int64(sPtr), block, b.ioCount)
+	if err != nil {
+		return fmt.Errorf("read segment index block at %d: %w", sPtr, err)
+	}
+
+	b.block = block
+	return nil
+}
+
+// region return the region of the region tail ptr, with the tails and heads memoised
+func (b *batch) region(regionPtr int64) (string, error) {
+	if region, has := b.regions[regionPtr]; has {
+		return region, nil
+	}
+
+	// the full region of the original xdb
+	if b.searcher.header.Format == UpstreamFormat {
+		var lk = getLookup()
+		buff, err := b.searcher.appendUpstreamRegion(nil, regionPtr, lk, b.ioCount)
+		putLookup(lk)
+		if err != nil {
+			return "", err
+		}
+
+		b.regions[regionPtr] = string(buff)
+		return b.regions[regionPtr], nil
+	}
+
+	headOffset, tail, err := b.searcher.readRegionTail(regionPtr, b.searcher.searchMode, b.ioCount)
+	if err != nil {
+		return "", err
+	}
+
+	head, has := b.heads[headOffset]
+	if !has {
+		headBuff, err := b.searcher.readRegionHead(headOffset, b.ioCount)
+		if err != nil {
+			return "", err
+		}
+		head = string(headBuff)
+		b.heads[headOffset] = head
+	}
+
+	region := strings.Join([]string{head, string(tail)}, REGION_STR_SEP)
+	b.regions[regionPtr] = region
+	return region, nil
+}
@@ -0,0 +1,69 @@
+package xdb
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchBatch(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	// the same random ips for every run
+	var r = rand.New(rand.NewSource(1))
+	var ips = make([]uint32, 2000)
+	for i := range ips {
+		ips[i] = r.Uint32()
+	}
+	// hit the segment boundaries and the duplicate ips
+	ips = append(ips, 0, 0x020C85FF, 0x020C8600, 0x020C8BFF, 0x020C8C00, 0x020D00FF, 0x020D0100, 0xFFFFFFFF, 0x020C8600)
+
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			regions, batchIO, err := searcher.SearchBatchWithIOCount(ips)
+			require.NoError(t, err)
+			require.Len(t, regions, len(ips))
+
+			var searchIO int
+			for i, ip := range ips {
+				region, ioCount, err := searcher.SearchWithIOCount(ip)
+				require.NoError(t, err)
+				assert.Equal(t, region, regions[i], Long2IP(ip))
+				searchIO += ioCount
+			}
+
+			if policy == CACHE_POLICY_FILE || policy == CACHE_POLICY_VECTOR {
+				assert.Less(t, batchIO, searchIO)
+			}
+		})
+	}
+}
+
+func TestSearchBatchByStr(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	regions, err := searcher.SearchBatchByStr([]string{"255.0.0.1", "2.12.134.0", "1.1.1.1", "2.12.134.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"美国|0|0|0",
+		"法国|Ille-et-Vilaine|0|橘子电信",
+		"中国|广东省|深圳市|电信",
+		"法国|Ille-et-Vilaine|0|橘子电信",
+	}, regions)
+
+	_, err = searcher.SearchBatchByStr([]string{"1.1.1.1", "1.1.1"})
+	assert.Error(t, err)
+
+	// the ipv6 xdb is refused before the ips are parsed
+	searcher, err = NewWithBuffer(buildTestDb(t, testSegmentsV6))
+	require.NoError(t, err)
+	_, err = searcher.SearchBatchByStr([]string{"2001:251::1"})
+	assert.ErrorIs(t, err, ErrIPVersionMismatch)
+	assert.NotErrorIs(t, err, ErrInvalidIP)
+}
@@ -0,0 +1,111 @@
+package xdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendSearch(t *testing.T) {
+	// the long tail is loaded with a second read
+	var longTail = strings.Repeat("长", 80)
+	segments := []testSegment{
+		{"0.0.0.0", "2.12.133.255", "中国|广东省", longTail},
+		{"2.12.134.0", "255.255.255.255", "美国|0", "0|0"},
+	}
+
+	dbPath := writeTestDb(t, segments)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			var dst = []byte("region: ")
+			dst, err = searcher.AppendSearchByStr(dst, "2.12.133.0")
+			require.NoError(t, err)
+			assert.Equal(t, "region: 中国|广东省|"+longTail, string(dst))
+
+			region, err := searcher.SearchByStr("2.12.134.0")
+			require.NoError(t, err)
+			assert.Equal(t, "美国|0|0|0", region)
+
+			_, err = searcher.AppendSearchByStr(nil, "2.12.134")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAppendSearchAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("skip the alloc counts with the race detector")
+	}
+
+	for _, segments := range [][]testSegment{testSegments, testSegmentsV6} {
+		dbPath := writeTestDb(t, segments)
+		for name, policy := range testCachePolicies {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+
+			var dst = make([]byte, 0, 256)
+			var ip = segments[1].sip
+			allocs := testing.AllocsPerRun(100, func() {
+				dst, err = searcher.AppendSearchByStr(dst[:0], ip)
+			})
+			require.NoError(t, err)
+			assert.Zero(t, allocs, "%s: %s", name, dst)
+
+			// the region string is the only allocation of Search
+			allocs = testing.AllocsPerRun(100, func() {
+				_, err = searcher.SearchByStr(ip)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, float64(1), allocs, name)
+			searcher.Close()
+		}
+	}
+}
+
+func benchmarkSearch(b *testing.B, segments []testSegment, appendRegion bool) {
+	dbPath := writeTestDb(b, segments)
+	for name, policy := range testCachePolicies {
+		b.Run(name, func(b *testing.B) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(b, err)
+			defer searcher.Close()
+
+			var dst = make([]byte, 0, 256)
+			var ip = segments[1].sip
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if appendRegion {
+					dst, err = searcher.AppendSearchByStr(dst[:0], ip)
+				} else {
+					_, err = searcher.SearchByStr(ip)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	benchmarkSearch(b, testSegments, false)
+}
+
+func BenchmarkAppendSearch(b *testing.B) {
+	benchmarkSearch(b, testSegments, true)
+}
+
+func BenchmarkSearchV6(b *testing.B) {
+	benchmarkSearch(b, testSegmentsV6, false)
+}
+
+func BenchmarkAppendSearchV6(b *testing.B) {
+	benchmarkSearch(b, testSegmentsV6, true)
+}
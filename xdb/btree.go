@@ -0,0 +1,128 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// segment index of the BTreeIndexPolicy.
+// the segments are not split with the vector slots, every segment is indexed with
+// one block of its full start and end ip, and the blocks are sorted by the start ip:
+// -- ipv4: [start ip 4B][end ip 4B][region tail ptr 4B], all little endian
+// -- ipv6: [start ip 16B][end ip 16B][region tail ptr 4B], the ips are big endian
+// the blocks lie in [StartIndexPtr, EndIndexPtr] with EndIndexPtr the ptr of the last block,
+// so the whole segment index could be binary searched without the vector index.
+// the vector index is still written by the maker, every slot points to the blocks
+// overlapped with the slot, and it narrows the search if it is in memory already.
+
+package xdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	BTreeRegionIndexBlockSize     = 12
+	IPv6BTreeRegionIndexBlockSize = 36
+)
+
+// IndexBlockSize return the size of the segment index block decided by the index policy and the ip version
+func (h *Header) IndexBlockSize() uint32 {
+	switch {
+	case h.Format == UpstreamFormat:
+		return UpstreamIndexBlockSize
+	case h.IndexPolicy == BTreeIndexPolicy && h.IPVersion == IPv6:
+		return IPv6BTreeRegionIndexBlockSize
+	case h.IndexPolicy == BTreeIndexPolicy:
+		return BTreeRegionIndexBlockSize
+	case h.IPVersion == IPv6:
+		return IPv6RegionIndexBlockSize
+	default:
+		return RegionIndexBlockSize
+	}
+}
+
+// btreeRange return the segment index blocks to search for the ip with the first two bytes,
+// the blocks of the vector slot if the vector index is in memory, or all the blocks without any read.
+// the ePtr is the end of the last block, the same as the vector index.
+func (s *Searcher) btreeRange(il0, il1 uint32, lk *lookup, ioCount *int) (sPtr uint32, ePtr uint32, err error) {
+	if s.vectorIndex.Load() != nil || s.contentBuff != nil {
+		return s.vectorBlock(il0, il1, lk, ioCount)
+	}
+
+	return s.header.StartIndexPtr, s.header.EndIndexPtr + s.header.IndexBlockSize(), nil
+}
+
+// locateBTree binary search the btree segment index for the long ip
+func (s *Searcher) locateBTree(ip uint32, lk *lookup, ioCount *int) (regionPtr int64, startIP uint32, endIP uint32, err error) {
+	sPtr, ePtr, err := s.btreeRange((ip>>24)&0xFF, (ip>>16)&0xFF, lk, ioCount)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var l, h = 0, int(ePtr-sPtr)/BTreeRegionIndexBlockSize - 1
+	for l <= h {
+		m := (l + h) >> 1
+		p := sPtr + uint32(m*BTreeRegionIndexBlockSize)
+		buff, err := s.view(int64(p), BTreeRegionIndexBlockSize, lk.index[:], ioCount)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("read segment index at %d: %w", p, err)
+		}
+
+		sip := binary.LittleEndian.Uint32(buff)
+		if ip < sip {
+			h = m - 1
+		} else {
+			eip := binary.LittleEndian.Uint32(buff[4:])
+			if ip > eip {
+				l = m + 1
+			} else {
+				return int64(binary.LittleEndian.Uint32(buff[8:])), sip, eip, nil
+			}
+		}
+	}
+
+	return 0, 0, 0, fmt.Errorf("%w: %s", ErrNotFound, Long2IP(ip))
+}
+
+// locateBTreeV6 binary search the btree segment index for the 16 bytes ipv6 address
+func (s *Searcher) locateBTreeV6(ip [16]byte, lk *lookup, ioCount *int) (regionPtr int64, startIP [16]byte, endIP [16]byte, err error) {
+	sPtr, ePtr, err := s.btreeRange(uint32(ip[0]), uint32(ip[1]), lk, ioCount)
+	if err != nil {
+		return 0, startIP, endIP, err
+	}
+
+	var l, h = 0, int(ePtr-sPtr)/IPv6BTreeRegionIndexBlockSize - 1
+	for l <= h {
+		m := (l + h) >> 1
+		p := sPtr + uint32(m*IPv6BTreeRegionIndexBlockSize)
+		buff, err := s.view(int64(p), IPv6BTreeRegionIndexBlockSize, lk.index[:], ioCount)
+		if err != nil {
+			return 0, startIP, endIP, fmt.Errorf("read segment index at %d: %w", p, err)
+		}
+
+		if bytes.Compare(ip[:], buff[:16]) < 0 {
+			h = m - 1
+		} else if bytes.Compare(ip[:], buff[16:32]) > 0 {
+			l = m + 1
+		} else {
+			copy(startIP[:], buff[:16])
+			copy(endIP[:], buff[16:32])
+			return int64(binary.LittleEndian.Uint32(buff[32:])), startIP, endIP, nil
+		}
+	}
+
+	return 0, startIP, endIP, fmt.Errorf("%w: %s", ErrNotFound, IPv6ToString(ip))
+}
+
+// btreeBlockIPs return the start and end ip of the btree segment index block as big endian bytes
+func btreeBlockIPs(buff []byte, ipVersion IPVersion) ([]byte, []byte) {
+	if ipVersion == IPv6 {
+		return buff[:16], buff[16:32]
+	}
+
+	var sip, eip = make([]byte, 4), make([]byte, 4)
+	binary.BigEndian.PutUint32(sip, binary.LittleEndian.Uint32(buff))
+	binary.BigEndian.PutUint32(eip, binary.LittleEndian.Uint32(buff[4:]))
+	return sip, eip
+}
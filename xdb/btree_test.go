@@ -0,0 +1,166 @@
+package xdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestBTreeDb write the fixture db with the btree segment index into a temp file
+func writeTestBTreeDb(t *testing.T, segments []testSegment) string {
+	t.Helper()
+
+	var dbPath = filepath.Join(t.TempDir(), "btree.xdb")
+	require.NoError(t, os.WriteFile(dbPath, buildTestDbWithPolicy(t, segments, BTreeIndexPolicy), 0600))
+	return dbPath
+}
+
+func TestBTreeSearch(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0":         "中国|广东省|深圳市|电信",
+		"2.12.133.255":    "中国|广东省|深圳市|电信",
+		"2.12.134.0":      "法国|Ille-et-Vilaine|0|橘子电信",
+		"2.12.140.0":      "法国|0|0|橘子电信",
+		"2.13.0.255":      "法国|0|0|橘子电信",
+		"2.13.1.0":        "美国|0|0|0",
+		"255.255.255.255": "美国|0|0|0",
+	}
+
+	dbPath := writeTestBTreeDb(t, testSegments)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+			assert.Equal(t, BTreeIndexPolicy, searcher.GetHeader().IndexPolicy)
+
+			for ip, expect := range cases {
+				region, err := searcher.SearchByStr(ip)
+				require.NoError(t, err, ip)
+				assert.Equal(t, expect, region, ip)
+			}
+
+			// the range is the full segment without the /16 split
+			r, err := searcher.SearchRangeByStr("2.13.0.1")
+			require.NoError(t, err)
+			assert.Equal(t, "2.12.140.0|2.13.0.255|法国|0|0|橘子电信", r.String())
+
+			regions, err := searcher.SearchBatchByStr([]string{"2.12.140.0", "0.0.0.0", "2.13.0.255"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"法国|0|0|橘子电信", "中国|广东省|深圳市|电信", "法国|0|0|橘子电信"}, regions)
+
+			assert.Equal(t, testSegments, collectSegments(t, searcher))
+		})
+	}
+}
+
+func TestBTreeSearchV6(t *testing.T) {
+	cases := map[string]string{
+		"::":              "0|0|0|0",
+		"2001:250::1":     "0|0|0|0",
+		"2001:251::1":     "中国|北京|北京市|教育网",
+		"2001:251::1:0":   "美国|0|0|0",
+		"2400:1::":        "美国|0|0|0",
+		"ffff::ffff:ffff": "日本|东京都|0|0",
+	}
+
+	dbPath := writeTestBTreeDb(t, testSegmentsV6)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			for ip, expect := range cases {
+				region, err := searcher.SearchByStr(ip)
+				require.NoError(t, err, ip)
+				assert.Equal(t, expect, region, ip)
+			}
+		})
+	}
+}
+
+func TestBTreeSearchPartial(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegmentsPartial, testSegmentsPartialV6} {
+		dbPath := writeTestBTreeDb(t, segments)
+		for name, policy := range testCachePolicies {
+			t.Run(name, func(t *testing.T) {
+				searcher, err := Create(dbPath, policy)
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				for _, seg := range segments {
+					for _, ip := range []string{seg.sip, seg.eip} {
+						region, err := searcher.SearchByStr(ip)
+						require.NoError(t, err, ip)
+						assert.Equal(t, seg.head+REGION_STR_SEP+seg.tail, region, ip)
+					}
+				}
+
+				for _, ip := range []string{"0.0.0.0", "1.0.1.5", "3.0.5.0", "255.255.255.255", "::", "2001:251::1:0", "ffff::"} {
+					if _, err := CheckIP(ip); (err == nil) != (searcher.GetHeader().IPVersion == IPv4) {
+						continue
+					}
+
+					_, err := searcher.SearchByStr(ip)
+					assert.ErrorIs(t, err, ErrNotFound, ip)
+				}
+			})
+		}
+	}
+}
+
+func TestBTreeSearchWithIOCount(t *testing.T) {
+	// no vector index read in the file mode, the segment index is binary searched globally
+	dbPath := writeTestBTreeDb(t, testSegments)
+	expectIO := map[CachePolicy]int{
+		CACHE_POLICY_FILE:   3,
+		CACHE_POLICY_VECTOR: 3,
+		CACHE_POLICY_MEMORY: 0,
+		CACHE_POLICY_MMAP:   0,
+	}
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			region, ioCount, err := searcher.SearchByStrWithIOCount("2.12.139.255")
+			require.NoError(t, err)
+			assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+			assert.Equal(t, expectIO[policy], ioCount)
+		})
+	}
+}
+
+func TestBTreeCheck(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegments, testSegmentsV6, testSegmentsPartial, testSegmentsPartialV6} {
+		searcher, err := NewWithFileOnly(writeTestFile(t, setTestChecksums(t, buildTestDbWithPolicy(t, segments, BTreeIndexPolicy))))
+		require.NoError(t, err)
+
+		report, err := searcher.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "%v", report.Problems)
+		assert.Equal(t, len(segments), report.Entries)
+		searcher.Close()
+	}
+
+	// swap the first two blocks out of order
+	var buff = buildTestDbWithPolicy(t, testSegments, BTreeIndexPolicy)
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+	var first = header.StartIndexPtr
+	var block = append([]byte(nil), buff[first:first+BTreeRegionIndexBlockSize]...)
+	copy(buff[first:], buff[first+BTreeRegionIndexBlockSize:first+2*BTreeRegionIndexBlockSize])
+	copy(buff[first+BTreeRegionIndexBlockSize:], block)
+
+	searcher, err := NewWithFileOnly(writeTestFile(t, setTestChecksums(t, buff)))
+	require.NoError(t, err)
+	defer searcher.Close()
+	report, err := searcher.Check()
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+}
@@ -0,0 +1,311 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// size bounded LRU result cache in front of the searcher.
+// the ipv4 results are cached by the matched range, so all the ips of
+// a cached range hit the cache, and the ipv6 results are cached by ip.
+// the entries are split into shards by the pre-two bytes of the ip, every shard
+// has its own lock and LRU list, and the small cache is one shard of the exact LRU.
+// the ranges of a /16 slot are kept sorted by the start ip and binary searched.
+// the ranges of the btree xdb could cross the /16 boundary, such a range is
+// one entry shared by all the /16 slots of the same shard it is searched in.
+// the cache is purged on every reload of a ReloadableSearcher.
+
+package xdb
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// the max shards of the cache, and the min capacity of a shard
+	cacheShards        = 16
+	cacheShardCapacity = 256
+)
+
+// CacheSource is the searcher behind the CachedSearcher,
+// both Searcher and ReloadableSearcher implement it
+type CacheSource interface {
+	GetHeader() *Header
+	SearchRange(ip uint32) (*IPRange, error)
+	SearchV6(ip [16]byte) (string, error)
+}
+
+// CacheStats is the statistics of the CachedSearcher
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+type cacheEntry struct {
+	// ipv4 entry, and the slots it is filed under
+	slots   []uint32
+	ipRange IPRange
+
+	// ipv6 entry
+	ip     [16]byte
+	region string
+	isV6   bool
+}
+
+type cacheShard struct {
+	capacity int
+
+	lock sync.Mutex
+	// the front is the most recently used
+	lru *list.List
+	// ipv4 entries by the pre-two bytes of the ip searched sorted by the range start ip,
+	// and by the range start ip
+	slots  map[uint32][]*list.Element
+	ranges map[uint32]*list.Element
+	// ipv6 entries by ip
+	ips map[[16]byte]*list.Element
+	// increased by every purge, the results searched before a purge are not cached
+	generation uint64
+}
+
+type CachedSearcher struct {
+	src    CacheSource
+	shards []*cacheShard
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCachedSearcher create a result cache with at most capacity entries in front of the src,
+// it is safe for concurrent use, and it is purged on every reload if the src is a ReloadableSearcher.
+func NewCachedSearcher(src CacheSource, capacity int) (*CachedSearcher, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("invalid cache capacity `%d`", capacity)
+	}
+
+	var count = capacity / cacheShardCapacity
+	if count < 1 {
+		count = 1
+	} else if count > cacheShards {
+		count = cacheShards
+	}
+
+	c := &CachedSearcher{src: src}
+	for i := 0; i < count; i++ {
+		// the capacities of the shards add up to the capacity
+		shard := &cacheShard{capacity: capacity / count}
+		if i < capacity%count {
+			shard.capacity++
+		}
+		shard.reset()
+		c.shards = append(c.shards, shard)
+	}
+
+	if reloadable, ok := src.(interface{ OnReload(func()) }); ok {
+		reloadable.OnReload(c.Purge)
+	}
+
+	return c, nil
+}
+
+// shard return the shard of the pre-two bytes of the ip
+func (c *CachedSearcher) shard(slot uint32) *cacheShard {
+	return c.shards[slot%uint32(len(c.shards))]
+}
+
+// SearchByStr find the region for the specified ip string
+func (c *CachedSearcher) SearchByStr(str string) (string, error) {
+	if c.src.GetHeader().IPVersion == IPv6 {
+		ip, err := CheckIPv6(str)
+		if err != nil {
+			return "", err
+		}
+
+		return c.SearchV6(ip)
+	}
+
+	ip, err := CheckIP(str)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Search(ip)
+}
+
+// Search find the region for the specified long ip
+func (c *CachedSearcher) Search(ip uint32) (string, error) {
+	r, err := c.SearchRange(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Region, nil
+}
+
+// SearchRange find the region and the matched range for the specified long ip
+func (c *CachedSearcher) SearchRange(ip uint32) (*IPRange, error) {
+	var slot = ip >> 16
+	var shard = c.shard(slot)
+	shard.lock.Lock()
+	if elem := shard.find(slot, ip); elem != nil {
+		shard.lru.MoveToFront(elem)
+		r := elem.Value.(*cacheEntry).ipRange
+		shard.lock.Unlock()
+		c.hits.Add(1)
+		return &r, nil
+	}
+	generation := shard.generation
+	shard.lock.Unlock()
+
+	c.misses.Add(1)
+	r, err := c.src.SearchRange(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if generation != shard.generation || shard.find(slot, ip) != nil {
+		// purged, or cached by other searches already
+		return r, nil
+	}
+
+	// the range crossing the /16 boundary is cached by the search in another slot
+	if elem, has := shard.ranges[r.StartIP]; has {
+		entry := elem.Value.(*cacheEntry)
+		entry.slots = append(entry.slots, slot)
+		shard.insert(slot, elem)
+		shard.lru.MoveToFront(elem)
+		return r, nil
+	}
+
+	elem := shard.lru.PushFront(&cacheEntry{slots: []uint32{slot}, ipRange: *r})
+	shard.insert(slot, elem)
+	shard.ranges[r.StartIP] = elem
+	shard.evict()
+	return r, nil
+}
+
+// SearchV6 find the region for the specified 16 bytes ipv6 address
+func (c *CachedSearcher) SearchV6(ip [16]byte) (string, error) {
+	var shard = c.shard(uint32(ip[0])<<8 | uint32(ip[1]))
+	shard.lock.Lock()
+	if elem, has := shard.ips[ip]; has {
+		shard.lru.MoveToFront(elem)
+		region := elem.Value.(*cacheEntry).region
+		shard.lock.Unlock()
+		c.hits.Add(1)
+		return region, nil
+	}
+	generation := shard.generation
+	shard.lock.Unlock()
+
+	c.misses.Add(1)
+	region, err := c.src.SearchV6(ip)
+	if err != nil {
+		return "", err
+	}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if _, has := shard.ips[ip]; has || generation != shard.generation {
+		return region, nil
+	}
+
+	shard.ips[ip] = shard.lru.PushFront(&cacheEntry{ip: ip, region: region, isV6: true})
+	shard.evict()
+	return region, nil
+}
+
+// search return the index of the first range of the slot starting after the ip, with the lock held
+func (s *cacheShard) search(elems []*list.Element, ip uint32) int {
+	return sort.Search(len(elems), func(i int) bool {
+		return elems[i].Value.(*cacheEntry).ipRange.StartIP > ip
+	})
+}
+
+// find return the cached range of the slot containing the ip, with the lock held.
+// the ranges of a slot never overlap, so only the last one starting before the ip is checked.
+func (s *cacheShard) find(slot uint32, ip uint32) *list.Element {
+	elems := s.slots[slot]
+	i := s.search(elems, ip)
+	if i > 0 && elems[i-1].Value.(*cacheEntry).ipRange.Contains(ip) {
+		return elems[i-1]
+	}
+
+	return nil
+}
+
+// insert file the range entry under the slot in the start ip order, with the lock held
+func (s *cacheShard) insert(slot uint32, elem *list.Element) {
+	elems := s.slots[slot]
+	i := s.search(elems, elem.Value.(*cacheEntry).ipRange.StartIP)
+	elems = append(elems, nil)
+	copy(elems[i+1:], elems[i:])
+	elems[i] = elem
+	s.slots[slot] = elems
+}
+
+// evict the least recently used entries beyond the capacity, with the lock held
+func (s *cacheShard) evict() {
+	for s.lru.Len() > s.capacity {
+		elem := s.lru.Back()
+		s.lru.Remove(elem)
+		entry := elem.Value.(*cacheEntry)
+		if entry.isV6 {
+			delete(s.ips, entry.ip)
+			continue
+		}
+
+		delete(s.ranges, entry.ipRange.StartIP)
+		for _, slot := range entry.slots {
+			elems := s.slots[slot]
+			i := s.search(elems, entry.ipRange.StartIP) - 1
+			if i >= 0 && elems[i] == elem {
+				elems = append(elems[:i], elems[i+1:]...)
+			}
+			if len(elems) == 0 {
+				delete(s.slots, slot)
+			} else {
+				s.slots[slot] = elems
+			}
+		}
+	}
+}
+
+// reset remove all the entries, with the lock held
+func (s *cacheShard) reset() {
+	s.generation++
+	s.lru = list.New()
+	s.slots = map[uint32][]*list.Element{}
+	s.ranges = map[uint32]*list.Element{}
+	s.ips = map[[16]byte]*list.Element{}
+}
+
+// Purge remove all the cached entries
+func (c *CachedSearcher) Purge() {
+	for _, shard := range c.shards {
+		shard.lock.Lock()
+		shard.reset()
+		shard.lock.Unlock()
+	}
+}
+
+// Stats return the hit, miss counters and the entry number of the cache
+func (c *CachedSearcher) Stats() CacheStats {
+	var size int
+	for _, shard := range c.shards {
+		shard.lock.Lock()
+		size += shard.lru.Len()
+		shard.lock.Unlock()
+	}
+
+	return CacheStats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+}
@@ -0,0 +1,178 @@
+package xdb
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedSearcher(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+	cache, err := NewCachedSearcher(searcher, 2)
+	require.NoError(t, err)
+
+	// the ips of the same range share one entry
+	region, err := cache.SearchByStr("2.12.134.0")
+	require.NoError(t, err)
+	assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+	region, err = cache.SearchByStr("2.12.139.255")
+	require.NoError(t, err)
+	assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Size: 1}, cache.Stats())
+
+	// the least recently used entry is evicted
+	_, err = cache.SearchByStr("2.12.140.0")
+	require.NoError(t, err)
+	_, err = cache.SearchByStr("2.12.134.1")
+	require.NoError(t, err)
+	_, err = cache.SearchByStr("1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 3, Size: 2}, cache.Stats())
+	_, err = cache.SearchByStr("2.12.140.1")
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Size: 2}, cache.Stats())
+
+	cache.Purge()
+	assert.Equal(t, 0, cache.Stats().Size)
+
+	_, err = NewCachedSearcher(searcher, 0)
+	assert.Error(t, err)
+}
+
+func TestCachedSearcherBTree(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDbWithPolicy(t, testSegments, BTreeIndexPolicy))
+	require.NoError(t, err)
+	cache, err := NewCachedSearcher(searcher, 2)
+	require.NoError(t, err)
+
+	// the range crossing the /16 boundary is one entry for both the slots
+	for _, ip := range []string{"2.12.140.0", "2.13.0.1", "2.13.0.255", "2.12.200.1"} {
+		long, err := CheckIP(ip)
+		require.NoError(t, err)
+		r, err := cache.SearchRange(long)
+		require.NoError(t, err)
+		assert.Equal(t, "2.12.140.0|2.13.0.255|法国|0|0|橘子电信", r.String())
+	}
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2, Size: 1}, cache.Stats())
+
+	// the shared entry is evicted from both the slots
+	for _, ip := range []string{"1.1.1.1", "2.13.1.0"} {
+		_, err = cache.SearchByStr(ip)
+		require.NoError(t, err)
+	}
+	_, err = cache.SearchByStr("2.13.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 5, Size: 2}, cache.Stats())
+}
+
+func TestCachedSearcherV6(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsV6))
+	require.NoError(t, err)
+	cache, err := NewCachedSearcher(searcher, 16)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		region, err := cache.SearchByStr("2001:251::1")
+		require.NoError(t, err)
+		assert.Equal(t, "中国|北京|北京市|教育网", region)
+	}
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Size: 1}, cache.Stats())
+}
+
+func TestCachedSearcherConcurrent(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+	cache, err := NewCachedSearcher(searcher, 64)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 1000; i++ {
+				ip := r.Uint32()
+				region, err := cache.Search(ip)
+				expect, _ := searcher.Search(ip)
+				if err != nil || region != expect {
+					t.Errorf("cached search `%s`: %s != %s, %v", Long2IP(ip), region, expect, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(16000), stats.Hits+stats.Misses)
+	assert.LessOrEqual(t, stats.Size, 64)
+}
+
+func TestCachedSearcherSharded(t *testing.T) {
+	for _, policy := range []IndexPolicy{VectorIndexPolicy, BTreeIndexPolicy} {
+		searcher, err := NewWithBuffer(buildTestDbWithPolicy(t, testSegments, policy))
+		require.NoError(t, err)
+		cache, err := NewCachedSearcher(searcher, 4096)
+		require.NoError(t, err)
+		assert.Len(t, cache.shards, cacheShards)
+
+		// the ranges of a slot are cached in any order and found by binary search
+		var ips []uint32
+		for _, seg := range testSegments {
+			sip, err := CheckIP(seg.sip)
+			require.NoError(t, err)
+			eip, err := CheckIP(seg.eip)
+			require.NoError(t, err)
+			ips = append(ips, sip, eip, sip+(eip-sip)/2)
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 64; i++ {
+			// the slots 2.12 and 2.13 hold several ranges
+			ips = append(ips, 0x020C0000+r.Uint32()%0x20000)
+		}
+		for round := 0; round < 2; round++ {
+			r.Shuffle(len(ips), func(i, j int) { ips[i], ips[j] = ips[j], ips[i] })
+			for _, ip := range ips {
+				expect, err := searcher.SearchRange(ip)
+				require.NoError(t, err)
+				got, err := cache.SearchRange(ip)
+				require.NoError(t, err)
+				assert.Equal(t, expect.String(), got.String(), Long2IP(ip))
+			}
+		}
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(2*len(ips)), stats.Hits+stats.Misses)
+		assert.LessOrEqual(t, stats.Size, 4096)
+		assert.GreaterOrEqual(t, stats.Hits, uint64(len(ips)))
+
+		cache.Purge()
+		assert.Equal(t, 0, cache.Stats().Size)
+	}
+}
+
+func TestCachedSearcherReload(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	searcher, err := NewReloadableSearcher(dbPath, CACHE_POLICY_MEMORY)
+	require.NoError(t, err)
+	defer searcher.Close()
+	cache, err := NewCachedSearcher(searcher, 16)
+	require.NoError(t, err)
+
+	region, err := cache.SearchByStr("1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "中国|广东省|深圳市|电信", region)
+
+	replaceTestDb(t, dbPath, buildTestDb(t, testSegmentsNext))
+	require.NoError(t, searcher.Reload())
+	assert.Equal(t, 0, cache.Stats().Size)
+
+	region, err = cache.SearchByStr("1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "中国|广东省|广州市|联通", region)
+}
@@ -0,0 +1,401 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// structural check of the xdb.
+// unlike Verify which compares the checksums recorded by the maker, Check walks
+// the vector index, the segment index and the region blocks, and makes sure
+// every pointer lands on a valid record, so it works for any xdb file.
+
+package xdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// the max problems kept in the CheckReport
+const maxCheckProblems = 100
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	// non-empty vector slots, segment index entries, distinct region tails and heads
+	Slots   int
+	Entries int
+	Tails   int
+	Heads   int
+
+	// the problems found, at most 100 of them are kept
+	Problems     []string
+	ProblemCount int
+}
+
+// OK check if no problem found
+func (r *CheckReport) OK() bool {
+	return r.ProblemCount == 0
+}
+
+func (r *CheckReport) addProblem(format string, args ...interface{}) {
+	r.ProblemCount++
+	if len(r.Problems) < maxCheckProblems {
+		r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// IsPartial check if the xdb is made from the segments not covering the whole ip space,
+// the uncovered ips are searched with ErrNotFound
+func (h *Header) IsPartial() bool {
+	return h.Flags&HeaderFlagPartial != 0
+}
+
+// Check validate the internal consistency of the xdb:
+// -- the header pointers and the checksums if recorded
+// -- every non-empty vector slot points inside [StartIndexPtr, EndIndexPtr]
+// -- the segment index entries of a slot are sorted, contiguous and cover the whole slot,
+// gaps between the entries and empty slots are allowed for the partial xdb
+// -- every region tail ptr and head offset lands on a valid length-prefixed record
+// -- all the region strings are valid utf-8
+// the whole xdb is loaded for the searchers not in memory.
+// the error is returned only if the check could not be done, eg: an io error.
+func (s *Searcher) Check() (*CheckReport, error) {
+	var report = &CheckReport{}
+	err := s.Verify()
+	if err != nil {
+		if !errors.Is(err, ErrCorruptDB) {
+			return nil, err
+		}
+
+		// the structure is not walked with invalid header pointers
+		report.addProblem("%s", err)
+		if s.header.Validate(s.size) != nil {
+			return report, nil
+		}
+	}
+
+	var content = s.contentBuff
+	if content == nil {
+		var ioCount int
+		content = make([]byte, s.size)
+		err = s.read(0, content, &ioCount)
+		if err != nil {
+			return nil, fmt.Errorf("read the xdb: %w", err)
+		}
+	}
+
+	var c = &checker{
+		header:  s.header,
+		content: content,
+		report:  report,
+		tails:   map[uint32]bool{},
+		heads:   map[uint32]bool{},
+	}
+	c.check()
+	return report, nil
+}
+
+type checker struct {
+	header  *Header
+	content []byte
+	report  *CheckReport
+
+	// the checked region tail ptrs and head offsets
+	tails map[uint32]bool
+	heads map[uint32]bool
+}
+
+func (c *checker) check() {
+	var h = c.header
+	var blockSize, tailLen = h.IndexBlockSize(), 2
+	if h.IPVersion == IPv6 {
+		tailLen = IPv6TailLength
+	}
+
+	// the original xdb keeps the full ips in the index blocks the same as the btree one
+	var btree = h.IndexPolicy == BTreeIndexPolicy || h.Format == UpstreamFormat
+	if btree {
+		c.checkBTree(blockSize)
+	}
+
+	for slot := uint32(0); slot < VectorIndexRows*VectorIndexCols; slot++ {
+		var idx = HeaderInfoLength + slot*VectorIndexSize
+		var sPtr = binary.LittleEndian.Uint32(c.content[idx:])
+		var ePtr = binary.LittleEndian.Uint32(c.content[idx+4:])
+		if sPtr == 0 && ePtr == 0 {
+			if !h.IsPartial() {
+				c.report.addProblem("slot %s: empty vector index", slotName(slot, h.IPVersion))
+			}
+			continue
+		}
+
+		c.report.Slots++
+		if btree {
+			c.checkBTreeSlot(slot, sPtr, ePtr, blockSize)
+		} else {
+			c.checkSlot(slot, sPtr, ePtr, blockSize, tailLen)
+		}
+	}
+
+	c.report.Tails = len(c.tails)
+	c.report.Heads = len(c.heads)
+}
+
+func (c *checker) checkSlot(slot uint32, sPtr uint32, ePtr uint32, blockSize uint32, tailLen int) {
+	var h = c.header
+	var name = slotName(slot, h.IPVersion)
+	if ePtr < sPtr || (ePtr-sPtr)%blockSize != 0 {
+		c.report.addProblem("slot %s: invalid vector index (%d, %d)", name, sPtr, ePtr)
+		return
+	}
+
+	// the reserved slot is a single index block with the same sPtr and ePtr
+	var count = (ePtr - sPtr) / blockSize
+	var lastPtr = ePtr - blockSize
+	if count == 0 {
+		count, lastPtr = 1, sPtr
+	}
+
+	// the reserved index block is written right before the StartIndexPtr by the old makers
+	var startIndexPtr = h.StartIndexPtr
+	if sPtr == ePtr && sPtr+blockSize == startIndexPtr {
+		startIndexPtr = sPtr
+	}
+
+	if sPtr < startIndexPtr || lastPtr > h.EndIndexPtr || int64(lastPtr)+int64(blockSize) > int64(len(c.content)) {
+		c.report.addProblem("slot %s: index block (%d, %d) out of the segment index (%d, %d)", name, sPtr, ePtr, h.StartIndexPtr, h.EndIndexPtr)
+		return
+	}
+
+	// the entries should start with the zero tail, end with the max tail and be contiguous,
+	// or be sorted without overlapping for the partial xdb
+	var expect = make([]byte, tailLen)
+	var last = bytes.Repeat([]byte{0xFF}, tailLen)
+	for i := uint32(0); i < count; i++ {
+		var buff = c.content[sPtr+i*blockSize:]
+		var sTail, eTail = entryTails(buff, tailLen)
+		c.report.Entries++
+
+		if h.IsPartial() {
+			if bytes.Compare(sTail, expect) < 0 {
+				c.report.addProblem("slot %s: entry %d starts at %x, overlapped with the last entry", name, i, sTail)
+			}
+		} else if !bytes.Equal(sTail, expect) {
+			c.report.addProblem("slot %s: entry %d starts at %x, expected %x", name, i, sTail, expect)
+		}
+
+		if bytes.Compare(sTail, eTail) > 0 {
+			c.report.addProblem("slot %s: entry %d start %x greater than end %x", name, i, sTail, eTail)
+		}
+
+		c.checkTail("slot "+name, binary.LittleEndian.Uint32(buff[tailLen*2:]))
+
+		if bytes.Equal(eTail, last) {
+			if i != count-1 {
+				c.report.addProblem("slot %s: entry %d ends the slot, with %d entries behind", name, i, count-1-i)
+			}
+			return
+		}
+		expect = nextTail(eTail)
+	}
+
+	if !h.IsPartial() {
+		c.report.addProblem("slot %s: entries end at %x, expected %x", name, expect, last)
+	}
+}
+
+// checkBTree check the btree segment index blocks are sorted, contiguous and cover the whole ip space,
+// or sorted without overlapping for the partial xdb
+func (c *checker) checkBTree(blockSize uint32) {
+	var h = c.header
+	var ipLen = 4
+	if h.IPVersion == IPv6 {
+		ipLen = 16
+	}
+
+	var expect = make([]byte, ipLen)
+	var last = bytes.Repeat([]byte{0xFF}, ipLen)
+	var ended = false
+	for ptr := h.StartIndexPtr; ptr <= h.EndIndexPtr; ptr += blockSize {
+		var buff = c.content[ptr:]
+		var sip, eip = btreeBlockIPs(buff, h.IPVersion)
+		c.report.Entries++
+
+		if ended {
+			c.report.addProblem("index block at %d: behind the block ends the ip space", ptr)
+			return
+		}
+
+		if h.IsPartial() {
+			if bytes.Compare(sip, expect) < 0 {
+				c.report.addProblem("index block at %d: starts at %x, overlapped with the last block", ptr, sip)
+			}
+		} else if !bytes.Equal(sip, expect) {
+			c.report.addProblem("index block at %d: starts at %x, expected %x", ptr, sip, expect)
+		}
+
+		if bytes.Compare(sip, eip) > 0 {
+			c.report.addProblem("index block at %d: start %x greater than end %x", ptr, sip, eip)
+		}
+
+		if h.Format == UpstreamFormat {
+			c.checkUpstreamRegion(fmt.Sprintf("index block at %d", ptr), buff)
+		} else {
+			c.checkTail(fmt.Sprintf("index block at %d", ptr), binary.LittleEndian.Uint32(buff[ipLen*2:]))
+		}
+		if bytes.Equal(eip, last) {
+			ended = true
+			continue
+		}
+		expect = nextTail(eip)
+	}
+
+	if !ended && !h.IsPartial() {
+		c.report.addProblem("btree index blocks end at %x, expected %x", expect, last)
+	}
+}
+
+// checkBTreeSlot check the vector slot of the btree xdb points to the blocks overlapped with the slot
+func (c *checker) checkBTreeSlot(slot uint32, sPtr uint32, ePtr uint32, blockSize uint32) {
+	var h = c.header
+	var name = slotName(slot, h.IPVersion)
+	if ePtr <= sPtr || (ePtr-sPtr)%blockSize != 0 || (sPtr-h.StartIndexPtr)%blockSize != 0 ||
+		sPtr < h.StartIndexPtr || ePtr-blockSize > h.EndIndexPtr {
+		c.report.addProblem("slot %s: invalid vector index (%d, %d) of the btree index (%d, %d)", name, sPtr, ePtr, h.StartIndexPtr, h.EndIndexPtr)
+		return
+	}
+
+	// the slot range as big endian bytes
+	var ipLen = 4
+	if h.IPVersion == IPv6 {
+		ipLen = 16
+	}
+	var slotStart, slotEnd = make([]byte, ipLen), bytes.Repeat([]byte{0xFF}, ipLen)
+	binary.BigEndian.PutUint16(slotStart, uint16(slot))
+	binary.BigEndian.PutUint16(slotEnd, uint16(slot))
+
+	_, firstEnd := btreeBlockIPs(c.content[sPtr:], h.IPVersion)
+	lastStart, _ := btreeBlockIPs(c.content[ePtr-blockSize:], h.IPVersion)
+	if bytes.Compare(firstEnd, slotStart) < 0 || bytes.Compare(lastStart, slotEnd) > 0 {
+		c.report.addProblem("slot %s: index blocks (%d, %d) not overlapped with the slot", name, sPtr, ePtr)
+	}
+}
+
+// checkTail check the region tail record and its region head record
+func (c *checker) checkTail(name string, tailPtr uint32) {
+	if _, has := c.tails[tailPtr]; has {
+		return
+	}
+
+	var h = c.header
+	var infoSize = uint32(h.regionTailInfoSize())
+	c.tails[tailPtr] = true
+	if tailPtr < h.RegionHeadStartPtr || int64(tailPtr)+int64(infoSize) > int64(h.StartIndexPtr) {
+		c.report.addProblem("%s: region tail ptr %d out of the region block", name, tailPtr)
+		return
+	}
+
+	var tailLen, prefixSize, err = h.regionLength(c.content[tailPtr+infoSize-1 : h.StartIndexPtr])
+	if err != nil {
+		c.report.addProblem("region tail at %d: %v", tailPtr, err)
+		return
+	}
+
+	var tailStart = tailPtr + infoSize - 1 + uint32(prefixSize)
+	if tailStart+uint32(tailLen) > h.StartIndexPtr {
+		c.report.addProblem("region tail at %d: length %d out of the region block", tailPtr, tailLen)
+		return
+	}
+
+	var tail = c.content[tailStart : tailStart+uint32(tailLen)]
+	if !utf8.Valid(tail) {
+		c.report.addProblem("region tail at %d: invalid utf-8 string %q", tailPtr, tail)
+	}
+
+	var headOffset = uint32(h.regionHeadOffset(c.content[tailPtr:]))
+	if _, has := c.heads[headOffset]; has {
+		return
+	}
+
+	c.heads[headOffset] = true
+	// the wide head offset could overflow the uint32 ptr
+	if int64(h.RegionHeadStartPtr)+int64(headOffset) >= int64(h.StartIndexPtr) {
+		c.report.addProblem("region tail at %d: head offset %d out of the region block", tailPtr, headOffset)
+		return
+	}
+
+	var headPtr = h.RegionHeadStartPtr + headOffset
+	headLen, prefixSize, err := h.regionLength(c.content[headPtr:h.StartIndexPtr])
+	if err != nil {
+		c.report.addProblem("region head at %d: %v", headPtr, err)
+		return
+	}
+
+	// the 1 byte length heads are read in one block
+	var headStart = headPtr + uint32(prefixSize)
+	if (!h.VarintLength() && headLen >= REGION_BASE_BLOCK_SIZE) || headStart+uint32(headLen) > h.StartIndexPtr {
+		c.report.addProblem("region head at %d: invalid length %d", headPtr, headLen)
+		return
+	}
+
+	var head = c.content[headStart : headStart+uint32(headLen)]
+	if !utf8.Valid(head) {
+		c.report.addProblem("region head at %d: invalid utf-8 string %q", headPtr, head)
+	}
+}
+
+// checkUpstreamRegion check the full region of the original xdb index block
+func (c *checker) checkUpstreamRegion(name string, buff []byte) {
+	var length = uint32(binary.LittleEndian.Uint16(buff[8:]))
+	var ptr = binary.LittleEndian.Uint32(buff[10:])
+	if _, has := c.tails[ptr]; has {
+		return
+	}
+
+	c.tails[ptr] = true
+	if ptr < HeaderInfoLength+VectorIndexLength || ptr+length > c.header.StartIndexPtr {
+		c.report.addProblem("%s: region (%d, %d) out of the region block", name, ptr, length)
+		return
+	}
+
+	var region = c.content[ptr : ptr+length]
+	if !utf8.Valid(region) {
+		c.report.addProblem("region at %d: invalid utf-8 string %q", ptr, region)
+	}
+}
+
+// entryTails return the start and end ip tails of the segment index entry as big endian bytes
+func entryTails(buff []byte, tailLen int) ([]byte, []byte) {
+	if tailLen == IPv6TailLength {
+		return buff[:tailLen], buff[tailLen : tailLen*2]
+	}
+
+	var sTail, eTail = make([]byte, 2), make([]byte, 2)
+	binary.BigEndian.PutUint16(sTail, binary.LittleEndian.Uint16(buff))
+	binary.BigEndian.PutUint16(eTail, binary.LittleEndian.Uint16(buff[2:]))
+	return sTail, eTail
+}
+
+// nextTail return tail + 1 of the big endian bytes
+func nextTail(tail []byte) []byte {
+	var next = append([]byte(nil), tail...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// slotName return the prefix of the vector slot, eg: 1.2.0.0/16 or 2001::/16
+func slotName(slot uint32, ipVersion IPVersion) string {
+	if ipVersion == IPv6 {
+		return fmt.Sprintf("%x::/16", slot)
+	}
+
+	return fmt.Sprintf("%d.%d.0.0/16", slot>>8, slot&0xFF)
+}
@@ -0,0 +1,113 @@
+package xdb
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegments, testSegmentsReserved, testSegmentsV6} {
+		searcher, err := NewWithFileOnly(writeTestFile(t, setTestChecksums(t, buildTestDb(t, segments))))
+		require.NoError(t, err)
+
+		report, err := searcher.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "%v", report.Problems)
+		assert.Equal(t, VectorIndexRows*VectorIndexCols, report.Slots)
+		var heads = map[string]bool{}
+		for _, seg := range segments {
+			heads[seg.head] = true
+		}
+		assert.Equal(t, len(heads), report.Heads)
+		searcher.Close()
+	}
+}
+
+func TestCheckProblems(t *testing.T) {
+	var buff = buildTestDb(t, testSegments)
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+
+	// the segment index block of slot 2.12
+	var idx = HeaderInfoLength + (2*VectorIndexCols+12)*VectorIndexSize
+	var sPtr = binary.LittleEndian.Uint32(buff[idx:])
+
+	cases := map[string]func(buff []byte){
+		"discontinuous": func(buff []byte) {
+			binary.LittleEndian.PutUint16(buff[sPtr+RegionIndexBlockSize:], 0x8700)
+		},
+		"unsorted": func(buff []byte) {
+			binary.LittleEndian.PutUint16(buff[sPtr+2:], 0)
+		},
+		"tail ptr": func(buff []byte) {
+			binary.LittleEndian.PutUint32(buff[sPtr+4:], 1)
+		},
+		"head offset": func(buff []byte) {
+			var tailPtr = binary.LittleEndian.Uint32(buff[sPtr+4:])
+			binary.LittleEndian.PutUint16(buff[tailPtr:], 0xFFFF)
+		},
+		"utf-8": func(buff []byte) {
+			var tailPtr = binary.LittleEndian.Uint32(buff[sPtr+4:])
+			buff[tailPtr+REGION_BLOCK_INFO_SIZE] = 0xFF
+		},
+		"vector index": func(buff []byte) {
+			binary.LittleEndian.PutUint32(buff[idx:], header.StartIndexPtr-RegionIndexBlockSize*2)
+		},
+	}
+
+	for name, corrupt := range cases {
+		t.Run(name, func(t *testing.T) {
+			var flipped = append([]byte(nil), buff...)
+			corrupt(flipped)
+
+			searcher, err := NewWithBuffer(flipped)
+			require.NoError(t, err)
+
+			report, err := searcher.Check()
+			require.NoError(t, err)
+			assert.False(t, report.OK())
+			t.Log(report.Problems)
+		})
+	}
+}
+
+func TestCheckChecksum(t *testing.T) {
+	var buff = setTestChecksums(t, buildTestDb(t, testSegments))
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+
+	// the last byte of the last region tail `0|0`, still a valid record
+	buff[header.StartIndexPtr-1] = '1'
+
+	searcher, err := NewWithBuffer(buff)
+	require.NoError(t, err)
+
+	report, err := searcher.Check()
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ProblemCount)
+	assert.Contains(t, report.Problems[0], SectionRegion)
+}
+
+func TestCheckPartial(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegmentsPartial, testSegmentsPartialV6} {
+		var buff = buildTestDb(t, segments)
+		searcher, err := NewWithBuffer(buff)
+		require.NoError(t, err)
+
+		report, err := searcher.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "%v", report.Problems)
+
+		// the gaps are problems without the partial flag
+		binary.LittleEndian.PutUint16(buff[22:], 0)
+		searcher, err = NewWithBuffer(buff)
+		require.NoError(t, err)
+
+		report, err = searcher.Check()
+		require.NoError(t, err)
+		assert.False(t, report.OK())
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// checksums of the xdb sections.
+// the maker records the crc32 (IEEE) checksums in header[32:48] and sets the
+// HeaderFlagChecksum flag, the sections are:
+// -- header       : the 256 bytes header, with its own checksum field zeroed
+// -- vector index : the 512 KiB vector index
+// -- region       : from the region head start ptr to the segment index start ptr
+// -- segment index: from the segment index start ptr to the end, including the metadata block
+
+package xdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// the checksums are saved in header[32:48]
+	HeaderChecksumOffset = 32
+
+	// flags saved in header[22:24]
+	HeaderFlagChecksum uint16 = 1 << 0
+	// the xdb does not cover the whole ip space, see Header.IsPartial
+	HeaderFlagPartial uint16 = 1 << 1
+	// the region tail records save the region head offset in 4 bytes instead of 2,
+	// set when the region head block exceeds 64 KiB, see Header.WideHeadOffset
+	HeaderFlagWideHeadOffset uint16 = 1 << 2
+	// the region head and tail strings are prefixed with the uvarint length instead of 1 byte,
+	// set when any head or tail exceeds the 1 byte length limits, see Header.VarintLength
+	HeaderFlagVarintLength uint16 = 1 << 3
+)
+
+// the xdb sections covered by the checksums
+const (
+	SectionHeader       = "header"
+	SectionVectorIndex  = "vector index"
+	SectionRegion       = "region"
+	SectionSegmentIndex = "segment index"
+)
+
+// Checksums is the crc32 checksums of the xdb sections
+type Checksums struct {
+	Header       uint32
+	VectorIndex  uint32
+	Region       uint32
+	SegmentIndex uint32
+}
+
+// CorruptionError is returned when the xdb data is damaged, eg: truncated or bit flipped
+type CorruptionError struct {
+	Section string
+
+	// the recorded and the computed checksums of the section
+	Expected uint32
+	Actual   uint32
+
+	// the cause of the structural damage, nil for a checksum mismatch
+	Err error
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("corrupt xdb %s: %s", e.Section, e.Err)
+	}
+
+	return fmt.Sprintf("corrupt xdb %s: checksum mismatch, expected %08x, got %08x", e.Section, e.Expected, e.Actual)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruptDB
+}
+
+// HeaderChecksum compute the checksum of the header buffer, with the header checksum field zeroed
+func HeaderChecksum(header []byte) uint32 {
+	var buff = make([]byte, HeaderInfoLength)
+	copy(buff, header)
+	binary.LittleEndian.PutUint32(buff[HeaderChecksumOffset:], 0)
+	return crc32.ChecksumIEEE(buff)
+}
+
+// ComputeChecksums compute the checksums of all the sections of the xdb data of the specified size
+func ComputeChecksums(reader io.ReaderAt, size int64) (*Checksums, error) {
+	var buff = make([]byte, HeaderInfoLength)
+	err := readFullAt(reader, buff, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read the header: %w", err)
+	}
+
+	header, err := NewHeader(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksums = &Checksums{Header: HeaderChecksum(buff)}
+	var sections = []struct {
+		checksum *uint32
+		start    int64
+		end      int64
+	}{
+		{&checksums.VectorIndex, HeaderInfoLength, HeaderInfoLength + VectorIndexLength},
+		{&checksums.Region, int64(header.RegionHeadStartPtr), int64(header.StartIndexPtr)},
+		{&checksums.SegmentIndex, int64(header.StartIndexPtr), size},
+	}
+	for _, section := range sections {
+		if section.start > section.end || section.end > size {
+			return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid section range (%d, %d)", section.start, section.end)}
+		}
+
+		hash := crc32.NewIEEE()
+		_, err = io.Copy(hash, io.NewSectionReader(reader, section.start, section.end-section.start))
+		if err != nil {
+			return nil, fmt.Errorf("read section at %d: %w", section.start, err)
+		}
+		*section.checksum = hash.Sum32()
+	}
+
+	return checksums, nil
+}
+
+// HasChecksum check if the checksums are recorded in the xdb
+func (h *Header) HasChecksum() bool {
+	return h.Flags&HeaderFlagChecksum != 0
+}
+
+// VerifyHeader check the header checksum and the header pointers against the data size,
+// it costs only one read, and it is done by Create with the WithVerify option
+// for CACHE_POLICY_FILE and CACHE_POLICY_VECTOR.
+func (s *Searcher) VerifyHeader() error {
+	err := s.header.Validate(s.size)
+	if err != nil {
+		return err
+	}
+
+	if !s.header.HasChecksum() {
+		return nil
+	}
+
+	var ioCount int
+	var buff = make([]byte, HeaderInfoLength)
+	err = s.read(0, buff, &ioCount)
+	if err != nil {
+		return fmt.Errorf("read the header: %w", err)
+	}
+
+	if actual := HeaderChecksum(buff); actual != s.header.Checksums.Header {
+		return &CorruptionError{Section: SectionHeader, Expected: s.header.Checksums.Header, Actual: actual}
+	}
+
+	return nil
+}
+
+// Verify check the header and the checksums of all the sections,
+// the whole xdb is read for the searchers not in memory.
+// the xdb files made without the checksums are checked with the header pointers only.
+func (s *Searcher) Verify() error {
+	err := s.VerifyHeader()
+	if err != nil || !s.header.HasChecksum() {
+		return err
+	}
+
+	var reader = s.reader
+	if s.contentBuff != nil {
+		reader = bytes.NewReader(s.contentBuff)
+	}
+
+	checksums, err := ComputeChecksums(reader, s.size)
+	if err != nil {
+		return err
+	}
+
+	var expected = s.header.Checksums
+	for _, c := range []struct {
+		section          string
+		expected, actual uint32
+	}{
+		{SectionVectorIndex, expected.VectorIndex, checksums.VectorIndex},
+		{SectionRegion, expected.Region, checksums.Region},
+		{SectionSegmentIndex, expected.SegmentIndex, checksums.SegmentIndex},
+	} {
+		if c.expected != c.actual {
+			return &CorruptionError{Section: c.section, Expected: c.expected, Actual: c.actual}
+		}
+	}
+
+	return nil
+}
+
+// WithVerify verify the xdb once the searcher created,
+// all the checksums are verified for CACHE_POLICY_MEMORY and CACHE_POLICY_MMAP,
+// and only the header is verified for the others to keep the creation cheap.
+func WithVerify() Option {
+	return func(s *Searcher) error {
+		if s.contentBuff != nil {
+			return s.Verify()
+		}
+
+		return s.VerifyHeader()
+	}
+}
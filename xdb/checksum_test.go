@@ -0,0 +1,93 @@
+package xdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setTestChecksums record the checksums into the fixture db as the maker does
+func setTestChecksums(t *testing.T, buff []byte) []byte {
+	t.Helper()
+
+	binary.LittleEndian.PutUint16(buff[22:], binary.LittleEndian.Uint16(buff[22:])|HeaderFlagChecksum)
+	checksums, err := ComputeChecksums(bytes.NewReader(buff), int64(len(buff)))
+	require.NoError(t, err)
+
+	binary.LittleEndian.PutUint32(buff[HeaderChecksumOffset+4:], checksums.VectorIndex)
+	binary.LittleEndian.PutUint32(buff[HeaderChecksumOffset+8:], checksums.Region)
+	binary.LittleEndian.PutUint32(buff[HeaderChecksumOffset+12:], checksums.SegmentIndex)
+	binary.LittleEndian.PutUint32(buff[HeaderChecksumOffset:], HeaderChecksum(buff))
+	return buff
+}
+
+func writeTestFile(t *testing.T, buff []byte) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "test.xdb")
+	require.NoError(t, os.WriteFile(dbPath, buff, 0600))
+	return dbPath
+}
+
+func TestVerify(t *testing.T) {
+	buff := setTestChecksums(t, appendTestMetadata(t, buildTestDb(t, testSegments), &Metadata{DataVersion: "v1"}))
+	dbPath := writeTestFile(t, buff)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy, WithVerify())
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			assert.True(t, searcher.GetHeader().HasChecksum())
+			require.NoError(t, searcher.Verify())
+		})
+	}
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	buff := setTestChecksums(t, buildTestDb(t, testSegments))
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+
+	// flip a bit of a region tail
+	flipped := append([]byte(nil), buff...)
+	flipped[header.RegionHeadStartPtr+10] ^= 0x01
+	dbPath := writeTestFile(t, flipped)
+
+	_, err = Create(dbPath, CACHE_POLICY_MEMORY, WithVerify())
+	var corruption *CorruptionError
+	require.True(t, errors.As(err, &corruption), "%v", err)
+	assert.Equal(t, SectionRegion, corruption.Section)
+
+	// only the header is verified in the file mode, the full verify is on demand
+	searcher, err := Create(dbPath, CACHE_POLICY_FILE, WithVerify())
+	require.NoError(t, err)
+	err = searcher.Verify()
+	require.True(t, errors.As(err, &corruption), "%v", err)
+	assert.Equal(t, SectionRegion, corruption.Section)
+	searcher.Close()
+
+	// flip a bit of the header
+	flipped = append([]byte(nil), buff...)
+	flipped[4] ^= 0x01
+	_, err = Create(writeTestFile(t, flipped), CACHE_POLICY_FILE, WithVerify())
+	require.True(t, errors.As(err, &corruption), "%v", err)
+	assert.Equal(t, SectionHeader, corruption.Section)
+
+	// truncated
+	_, err = Create(writeTestFile(t, buff[:len(buff)-4]), CACHE_POLICY_MEMORY, WithVerify())
+	require.True(t, errors.As(err, &corruption), "%v", err)
+}
+
+func TestVerifyWithoutChecksum(t *testing.T) {
+	searcher, err := Create(writeTestDb(t, testSegments), CACHE_POLICY_MEMORY, WithVerify())
+	require.NoError(t, err)
+	assert.False(t, searcher.GetHeader().HasChecksum())
+	assert.NoError(t, searcher.Verify())
+}
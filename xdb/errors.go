@@ -0,0 +1,55 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// errors of the searcher and the maker.
+// the errors returned are wrapped with the context, test them with errors.Is
+// against the sentinel errors, or errors.As against the error types:
+// -- ErrInvalidIP          : bad input, eg: a malformed ip string
+// -- ErrIPVersionMismatch  : the ip version of the input does not match the xdb
+// -- ErrNotFound           : the ip is not covered by the xdb
+// -- ErrCorruptDB          : the xdb data is damaged, see CorruptionError
+// -- ErrUnsupportedVersion : the xdb is made by an unsupported maker
+// -- ErrInvalidSegment     : bad source line of the maker
+// -- ErrClosed             : search on a closed ReloadableSearcher
+// -- ErrDiscontinuousSegment: the source segments are not contiguous, see DiscontinuityError
+// the other errors, eg: the io errors of the reader, are returned wrapped as they are.
+
+package xdb
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+var (
+	ErrInvalidIP            = errors.New("invalid ip address")
+	ErrIPVersionMismatch    = errors.New("ip version mismatch")
+	ErrNotFound             = errors.New("region not found")
+	ErrCorruptDB            = errors.New("corrupt xdb")
+	ErrUnsupportedVersion   = errors.New("unsupported xdb version")
+	ErrInvalidCachePolicy   = errors.New("invalid cache policy")
+	ErrInvalidSegment       = errors.New("invalid ip segment")
+	ErrDiscontinuousSegment = errors.New("discontinuous data segment")
+	ErrClosed               = errors.New("searcher closed")
+)
+
+// DiscontinuityError is returned by the maker when a segment does not start
+// right after the end of the last one
+type DiscontinuityError struct {
+	// line number of the segment in the source file, starts from 1
+	Line int
+
+	LastEndIP netip.Addr
+	StartIP   netip.Addr
+}
+
+func (e *DiscontinuityError) Error() string {
+	return fmt.Sprintf("%s at line %d: last.eip+1(%s) != seg.sip(%s)", ErrDiscontinuousSegment, e.Line, e.LastEndIP.Next(), e.StartIP)
+}
+
+func (e *DiscontinuityError) Is(target error) bool {
+	return target == ErrDiscontinuousSegment
+}
@@ -0,0 +1,101 @@
+package xdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidIPErrors(t *testing.T) {
+	for _, ip := range []string{"1.2.3", "1.2.3.a", "1.2.3.256", ""} {
+		_, err := CheckIP(ip)
+		assert.ErrorIs(t, err, ErrInvalidIP, ip)
+	}
+
+	_, err := CheckIPv6("1.2.3.4")
+	assert.ErrorIs(t, err, ErrInvalidIP)
+
+	_, err = ParseRemoteAddr("not an address")
+	assert.ErrorIs(t, err, ErrInvalidIP)
+
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	_, err = searcher.SearchByStr("1.2.3.4.5")
+	assert.ErrorIs(t, err, ErrInvalidIP)
+
+	_, err = searcher.SearchV6([16]byte{})
+	assert.ErrorIs(t, err, ErrIPVersionMismatch)
+
+	_, err = searcher.SearchByAddr(netip.MustParseAddr("2001::1"))
+	assert.ErrorIs(t, err, ErrIPVersionMismatch)
+	var addrErr *UnsupportedAddrError
+	assert.True(t, errors.As(err, &addrErr))
+
+	_, err = searcher.SearchByAddr(netip.Addr{})
+	assert.ErrorIs(t, err, ErrInvalidIP)
+}
+
+func TestCorruptErrors(t *testing.T) {
+	var buff = buildTestDb(t, testSegments)
+
+	// unsupported version
+	var unsupported = append([]byte(nil), buff...)
+	binary.LittleEndian.PutUint16(unsupported, VersionExtended+1)
+	_, err := NewWithBuffer(unsupported)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.NotErrorIs(t, err, ErrCorruptDB)
+
+	// invalid header
+	var invalid = append([]byte(nil), buff...)
+	binary.LittleEndian.PutUint16(invalid[20:], 5)
+	_, err = NewWithBuffer(invalid)
+	var corruption *CorruptionError
+	require.True(t, errors.As(err, &corruption), "%v", err)
+	assert.Equal(t, SectionHeader, corruption.Section)
+	assert.ErrorIs(t, err, ErrCorruptDB)
+
+	_, err = NewWithBuffer(buff[:HeaderInfoLength-1])
+	assert.ErrorIs(t, err, ErrCorruptDB)
+
+	// truncated data, refused on load instead of on search
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+	for _, size := range []int64{HeaderInfoLength + 100, HeaderInfoLength + VectorIndexLength, int64(header.RegionHeadStartPtr) + 2} {
+		for name, policy := range testCachePolicies {
+			_, err = Create(writeTestFile(t, buff[:size]), policy)
+			require.True(t, errors.As(err, &corruption), "%s %d: %v", name, size, err)
+			assert.ErrorIs(t, err, ErrCorruptDB, name)
+		}
+
+		_, err = NewWithBuffer(buff[:size])
+		assert.True(t, errors.As(err, &corruption), "%d: %v", size, err)
+	}
+
+	// truncated vector index of the caller
+	vIndex, err := LoadVectorIndexFromFile(writeTestFile(t, buff))
+	require.NoError(t, err)
+	searcher, err := NewWithVectorIndex(writeTestFile(t, buff), vIndex[:VectorIndexLength/2])
+	require.NoError(t, err)
+	_, err = searcher.SearchByStr("255.255.255.255")
+	assert.ErrorIs(t, err, ErrCorruptDB)
+	searcher.Close()
+
+	_, err = Create(writeTestFile(t, buff), CachePolicy(100))
+	assert.ErrorIs(t, err, ErrInvalidCachePolicy)
+}
+
+func TestDiscontinuityError(t *testing.T) {
+	var err error = &DiscontinuityError{
+		Line:      2,
+		LastEndIP: netip.MustParseAddr("1.0.0.255"),
+		StartIP:   netip.MustParseAddr("1.0.2.0"),
+	}
+
+	assert.ErrorIs(t, err, ErrDiscontinuousSegment)
+	assert.Equal(t, "discontinuous data segment at line 2: last.eip+1(1.0.1.0) != seg.sip(1.0.2.0)", err.Error())
+}
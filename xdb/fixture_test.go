@@ -0,0 +1,394 @@
+package xdb
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSegment is a source line of the fixture db: startIP|endIP|head|tail
+type testSegment struct {
+	sip  string
+	eip  string
+	head string
+	tail string
+}
+
+// testSegments cover the whole ipv4 space
+var testSegments = []testSegment{
+	{"0.0.0.0", "2.12.133.255", "中国|广东省", "深圳市|电信"},
+	{"2.12.134.0", "2.12.139.255", "法国|Ille-et-Vilaine", "0|橘子电信"},
+	{"2.12.140.0", "2.13.0.255", "法国|0", "0|橘子电信"},
+	{"2.13.1.0", "255.255.255.255", "美国|0", "0|0"},
+}
+
+// testSegmentsReserved cover the whole ipv4 space with the reserved /16 aligned segments,
+// which are indexed as the reserved vector slots by the maker
+var testSegmentsReserved = []testSegment{
+	{"0.0.0.0", "0.255.255.255", testReservedHead, testReservedTail},
+	{"1.0.0.0", "9.255.255.255", "中国|广东省", "深圳市|电信"},
+	{"10.0.0.0", "10.255.255.255", testReservedHead, testReservedTail},
+	{"11.0.0.0", "255.255.255.255", "美国|0", "0|0"},
+}
+
+// the region of the reserved segments, the same as the maker
+const (
+	testReservedHead = "0|0"
+	testReservedTail = "内网IP|内网IP"
+)
+
+// testSegmentsV6 cover the whole ipv6 space
+var testSegmentsV6 = []testSegment{
+	{"::", "2001:250:ffff:ffff:ffff:ffff:ffff:ffff", "0|0", "0|0"},
+	{"2001:251::", "2001:251::ffff", "中国|北京", "北京市|教育网"},
+	{"2001:251::1:0", "2400:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "美国|0", "0|0"},
+	{"2401::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "日本|东京都", "0|0"},
+}
+
+// testSegmentsPartial leave gaps: empty slots, a gap inside the slot 1.0 and the tail of the slot 3.0
+var testSegmentsPartial = []testSegment{
+	{"1.0.0.0", "1.0.0.255", "中国|广东省", "深圳市|电信"},
+	{"1.0.2.0", "2.12.133.255", "法国|0", "0|橘子电信"},
+	{"3.0.0.0", "3.0.0.255", "日本|东京都", "0|0"},
+	{"3.1.0.0", "3.1.255.255", "美国|0", "0|0"},
+	{"10.0.0.0", "10.255.255.255", testReservedHead, testReservedTail},
+}
+
+// testSegmentsPartialV6 leave gaps of empty slots and inside the slot 2001:251::/16
+var testSegmentsPartialV6 = []testSegment{
+	{"2001:250::", "2001:250::ffff", "中国|北京", "北京市|教育网"},
+	{"2001:251::", "2001:251::ffff", "中国|北京", "北京市|教育网"},
+	{"2001:251::2:0", "2001:251::2:ffff", "美国|0", "0|0"},
+}
+
+// isPartialTestDb check if the segments do not cover the whole ip space
+func isPartialTestDb(t testing.TB, segments []testSegment) bool {
+	t.Helper()
+
+	var next netip.Addr
+	for i, seg := range segments {
+		sip, eip := netip.MustParseAddr(seg.sip), netip.MustParseAddr(seg.eip)
+		if i == 0 {
+			next = netip.IPv4Unspecified()
+			if sip.Is6() {
+				next = netip.IPv6Unspecified()
+			}
+		}
+
+		if sip != next {
+			return true
+		}
+		next = eip.Next()
+	}
+
+	// the Next of the max ip is invalid
+	return next.IsValid()
+}
+
+// buildTestDb build a xdb content buffer with the same layout as the maker does,
+// the ip version is decided by the first segment
+func buildTestDb(t testing.TB, segments []testSegment) []byte {
+	t.Helper()
+	return buildTestDbWithPolicy(t, segments, VectorIndexPolicy)
+}
+
+// buildTestDbWithPolicy build the fixture db with the segment index of the index policy
+func buildTestDbWithPolicy(t testing.TB, segments []testSegment, policy IndexPolicy) []byte {
+	t.Helper()
+
+	var ipVersion = IPv4
+	if strings.Contains(segments[0].sip, ":") {
+		ipVersion = IPv6
+	}
+
+	var buff = make([]byte, HeaderInfoLength+VectorIndexLength)
+	binary.LittleEndian.PutUint16(buff, VersionNo)
+	binary.LittleEndian.PutUint16(buff[2:], uint16(policy))
+	binary.LittleEndian.PutUint32(buff[4:], 1666666666)
+
+	// the varint length for the too long heads or tails, as the maker does
+	var varintLength bool
+	for _, seg := range segments {
+		if len(seg.head) >= REGION_BASE_BLOCK_SIZE || len(seg.tail) > 0xFF {
+			varintLength = true
+		}
+	}
+	var appendLength = func(dst []byte, str string) []byte {
+		if varintLength {
+			return binary.AppendUvarint(dst, uint64(len(str)))
+		}
+		return append(dst, uint8(len(str)))
+	}
+
+	// region heads
+	var headStartPtr = uint32(len(buff))
+	var headOffsets = map[string]uint32{}
+	for _, seg := range segments {
+		if _, has := headOffsets[seg.head]; has {
+			continue
+		}
+
+		headOffsets[seg.head] = uint32(len(buff)) - headStartPtr
+		buff = appendLength(buff, seg.head)
+		buff = append(buff, seg.head...)
+	}
+
+	// the 4 bytes head offsets for the head blocks over 64 KiB, as the maker does
+	var wideHeadOffset = uint32(len(buff))-headStartPtr > 0xFFFF
+	if wideHeadOffset || varintLength {
+		binary.LittleEndian.PutUint16(buff, VersionExtended)
+	}
+
+	// region tails
+	var tailPtrs = map[string]uint32{}
+	for _, seg := range segments {
+		var key = seg.head + REGION_STR_SEP + seg.tail
+		if _, has := tailPtrs[key]; has {
+			continue
+		}
+
+		tailPtrs[key] = uint32(len(buff))
+		if wideHeadOffset {
+			buff = binary.LittleEndian.AppendUint32(buff, headOffsets[seg.head])
+		} else {
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(headOffsets[seg.head]))
+		}
+		buff = appendLength(buff, seg.tail)
+		buff = append(buff, seg.tail...)
+	}
+
+	// segment index, split with the pre-two bytes
+	var startIndexPtr, endIndexPtr uint32
+	var setVector = func(il0, il1 uint32, ptr uint32, blockSize uint32) {
+		var idx = HeaderInfoLength + (il0*VectorIndexCols+il1)*VectorIndexSize
+		if binary.LittleEndian.Uint32(buff[idx:]) == 0 {
+			binary.LittleEndian.PutUint32(buff[idx:], ptr)
+		}
+		binary.LittleEndian.PutUint32(buff[idx+4:], ptr+blockSize)
+		if startIndexPtr == 0 {
+			startIndexPtr = ptr
+		}
+		endIndexPtr = ptr
+	}
+
+	// the reserved index block is written before the others, as the maker does
+	var reservedPtr uint32
+	var isReserved = func(seg testSegment, sip uint32, eip uint32) bool {
+		return seg.head == testReservedHead && seg.tail == testReservedTail &&
+			sip&IP_TAIL_PATTERN == 0 && eip&IP_TAIL_PATTERN == IP_TAIL_PATTERN
+	}
+	if ipVersion == IPv4 && policy == VectorIndexPolicy {
+		if tailPtr, has := tailPtrs[testReservedHead+REGION_STR_SEP+testReservedTail]; has {
+			reservedPtr = uint32(len(buff))
+			startIndexPtr, endIndexPtr = reservedPtr, reservedPtr
+			buff = binary.LittleEndian.AppendUint16(buff, 0)
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(IP_TAIL_PATTERN))
+			buff = binary.LittleEndian.AppendUint32(buff, tailPtr)
+		}
+	}
+
+	for _, seg := range segments {
+		if policy == BTreeIndexPolicy {
+			// one block of the full start and end ip for every segment
+			var ptr = uint32(len(buff))
+			var sSlot, eSlot uint32
+			var blockSize uint32 = BTreeRegionIndexBlockSize
+			if ipVersion == IPv6 {
+				sip, eip := netip.MustParseAddr(seg.sip).As16(), netip.MustParseAddr(seg.eip).As16()
+				buff = append(buff, sip[:]...)
+				buff = append(buff, eip[:]...)
+				sSlot, eSlot = uint32(binary.BigEndian.Uint16(sip[:])), uint32(binary.BigEndian.Uint16(eip[:]))
+				blockSize = IPv6BTreeRegionIndexBlockSize
+			} else {
+				sip, _ := CheckIP(seg.sip)
+				eip, _ := CheckIP(seg.eip)
+				buff = binary.LittleEndian.AppendUint32(buff, sip)
+				buff = binary.LittleEndian.AppendUint32(buff, eip)
+				sSlot, eSlot = sip>>16, eip>>16
+			}
+
+			buff = binary.LittleEndian.AppendUint32(buff, tailPtrs[seg.head+REGION_STR_SEP+seg.tail])
+			for slot := sSlot; slot <= eSlot; slot++ {
+				setVector(slot>>8, slot&0xFF, ptr, blockSize)
+			}
+			continue
+		}
+
+		if ipVersion == IPv6 {
+			sip, err := CheckIPv6(seg.sip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eip, err := CheckIPv6(seg.eip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var tailPtr = tailPtrs[seg.head+REGION_STR_SEP+seg.tail]
+			for {
+				var sEip = sip
+				for i := 2; i < len(sEip); i++ {
+					sEip[i] = 0xFF
+				}
+				if sEip[0] == eip[0] && sEip[1] == eip[1] {
+					sEip = eip
+				}
+
+				var ptr = uint32(len(buff))
+				buff = append(buff, sip[2:]...)
+				buff = append(buff, sEip[2:]...)
+				buff = binary.LittleEndian.AppendUint32(buff, tailPtr)
+				setVector(uint32(sip[0]), uint32(sip[1]), ptr, IPv6RegionIndexBlockSize)
+
+				if sEip == eip {
+					break
+				}
+
+				var prefix = (uint16(sip[0])<<8 | uint16(sip[1])) + 1
+				sip = [16]byte{byte(prefix >> 8), byte(prefix)}
+			}
+			continue
+		}
+
+		sip, err := CheckIP(seg.sip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eip, err := CheckIP(seg.eip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if isReserved(seg, sip, eip) {
+			for slot := sip >> 16; slot <= eip>>16; slot++ {
+				var idx = HeaderInfoLength + slot*VectorIndexSize
+				binary.LittleEndian.PutUint32(buff[idx:], reservedPtr)
+				binary.LittleEndian.PutUint32(buff[idx+4:], reservedPtr)
+			}
+			continue
+		}
+
+		var tailPtr = tailPtrs[seg.head+REGION_STR_SEP+seg.tail]
+		for {
+			var sEip = sip | IP_TAIL_PATTERN
+			if sEip > eip {
+				sEip = eip
+			}
+
+			var ptr = uint32(len(buff))
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(sip&IP_TAIL_PATTERN))
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(sEip&IP_TAIL_PATTERN))
+			buff = binary.LittleEndian.AppendUint32(buff, tailPtr)
+			setVector(sip>>24, (sip>>16)&0xFF, ptr, RegionIndexBlockSize)
+
+			if sEip == eip {
+				break
+			}
+			sip = sEip + 1
+		}
+	}
+
+	binary.LittleEndian.PutUint32(buff[8:], startIndexPtr)
+	binary.LittleEndian.PutUint32(buff[12:], endIndexPtr)
+	binary.LittleEndian.PutUint32(buff[16:], headStartPtr)
+	binary.LittleEndian.PutUint16(buff[20:], uint16(ipVersion))
+	var flags uint16
+	if isPartialTestDb(t, segments) {
+		flags |= HeaderFlagPartial
+	}
+	if wideHeadOffset {
+		flags |= HeaderFlagWideHeadOffset
+	}
+	if varintLength {
+		flags |= HeaderFlagVarintLength
+	}
+	binary.LittleEndian.PutUint16(buff[22:], flags)
+
+	var layout = "country|province|city|isp"
+	buff[HeaderFieldLayoutOffset] = uint8(len(layout))
+	copy(buff[HeaderFieldLayoutOffset+1:], layout)
+	return buff
+}
+
+// writeTestDb write the fixture db into a temp file and return the path
+func writeTestDb(t testing.TB, segments []testSegment) string {
+	t.Helper()
+
+	var dbPath = filepath.Join(t.TempDir(), "test.xdb")
+	if err := os.WriteFile(dbPath, buildTestDb(t, segments), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return dbPath
+}
+
+// buildUpstreamTestDb build the ipv4 fixture db with the layout of the original ip2region maker,
+// the full regions and the 14 bytes segment index blocks split with the pre-two bytes
+func buildUpstreamTestDb(t testing.TB, segments []testSegment) []byte {
+	t.Helper()
+
+	var buff = make([]byte, HeaderInfoLength+VectorIndexLength)
+	binary.LittleEndian.PutUint16(buff, VersionNo)
+	binary.LittleEndian.PutUint16(buff[2:], uint16(VectorIndexPolicy))
+	binary.LittleEndian.PutUint32(buff[4:], 1666666666)
+
+	// full regions
+	var regionPtrs = map[string]uint32{}
+	for _, seg := range segments {
+		var region = seg.head + REGION_STR_SEP + seg.tail
+		if _, has := regionPtrs[region]; has {
+			continue
+		}
+
+		regionPtrs[region] = uint32(len(buff))
+		buff = append(buff, region...)
+	}
+
+	var startIndexPtr, endIndexPtr uint32
+	for _, seg := range segments {
+		sip, err := CheckIP(seg.sip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		eip, err := CheckIP(seg.eip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var region = seg.head + REGION_STR_SEP + seg.tail
+		for {
+			var sEip = sip | IP_TAIL_PATTERN
+			if sEip > eip {
+				sEip = eip
+			}
+
+			var ptr = uint32(len(buff))
+			buff = binary.LittleEndian.AppendUint32(buff, sip)
+			buff = binary.LittleEndian.AppendUint32(buff, sEip)
+			buff = binary.LittleEndian.AppendUint16(buff, uint16(len(region)))
+			buff = binary.LittleEndian.AppendUint32(buff, regionPtrs[region])
+
+			var idx = HeaderInfoLength + (sip>>16)*VectorIndexSize
+			if binary.LittleEndian.Uint32(buff[idx:]) == 0 {
+				binary.LittleEndian.PutUint32(buff[idx:], ptr)
+			}
+			binary.LittleEndian.PutUint32(buff[idx+4:], ptr+UpstreamIndexBlockSize)
+			if startIndexPtr == 0 {
+				startIndexPtr = ptr
+			}
+			endIndexPtr = ptr
+
+			if sEip == eip {
+				break
+			}
+			sip = sEip + 1
+		}
+	}
+
+	binary.LittleEndian.PutUint32(buff[8:], startIndexPtr)
+	binary.LittleEndian.PutUint32(buff[12:], endIndexPtr)
+	return buff
+}
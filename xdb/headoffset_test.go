@@ -0,0 +1,79 @@
+package xdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWideSegments cover the ipv4 space with distinct heads exceeding 64 KiB of the head block
+func testWideSegments() []testSegment {
+	var segments []testSegment
+	const count, size = 4096, 1 << 20
+	for i := 0; i < count; i++ {
+		segments = append(segments, testSegment{
+			sip:  Long2IP(uint32(i * size)),
+			eip:  Long2IP(uint32(i*size + size - 1)),
+			head: fmt.Sprintf("国家%04d|省份%04d", i, i),
+			tail: fmt.Sprintf("城市%d|0", i%7),
+		})
+	}
+
+	return segments
+}
+
+func TestWideHeadOffsetSearch(t *testing.T) {
+	var segments = testWideSegments()
+	for _, policy := range []IndexPolicy{VectorIndexPolicy, BTreeIndexPolicy} {
+		var buff = setTestChecksums(t, buildTestDbWithPolicy(t, segments, policy))
+		dbPath := writeTestFile(t, buff)
+		for name, cachePolicy := range testCachePolicies {
+			t.Run(policy.String()+"/"+name, func(t *testing.T) {
+				searcher, err := Create(dbPath, cachePolicy, WithVerify())
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				var header = searcher.GetHeader()
+				assert.Equal(t, uint16(VersionExtended), header.Version)
+				assert.True(t, header.WideHeadOffset())
+
+				for _, seg := range segments {
+					for _, ip := range []string{seg.sip, seg.eip} {
+						region, err := searcher.SearchByStr(ip)
+						require.NoError(t, err, ip)
+						require.Equal(t, seg.head+REGION_STR_SEP+seg.tail, region, ip)
+					}
+				}
+
+				assert.Equal(t, segments, collectSegments(t, searcher))
+			})
+		}
+
+		searcher, err := NewWithFileOnly(dbPath)
+		require.NoError(t, err)
+		report, err := searcher.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "%v", report.Problems)
+		assert.Equal(t, len(segments), report.Heads)
+		searcher.Close()
+	}
+}
+
+func TestWideHeadOffsetVersion(t *testing.T) {
+	// the small xdb keeps the 2 bytes head offset and the original version
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+	assert.Equal(t, uint16(VersionNo), searcher.GetHeader().Version)
+	assert.False(t, searcher.GetHeader().WideHeadOffset())
+
+	// the wide head offset flag requires the extended version
+	var buff = buildTestDb(t, testWideSegments())
+	binary.LittleEndian.PutUint16(buff, VersionNo)
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+	err = header.Validate(int64(len(buff)))
+	assert.ErrorIs(t, err, ErrCorruptDB)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// build info of the xdb.
+// the maker records the build info as a json encoded metadata section,
+// located by the ptr and length in header[24:32].
+
+package xdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Metadata is the build info recorded by the maker
+type Metadata struct {
+	// release version and description of the data, set by the maker options
+	DataVersion string `json:"data_version,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// name and sha256 hex of the source file
+	SourceFile   string `json:"source_file,omitempty"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+
+	// count of the source segments, and the distinct region heads and tails
+	SegmentCount int `json:"segment_count"`
+	HeadCount    int `json:"head_count"`
+	TailCount    int `json:"tail_count"`
+
+	FieldLayout  []string `json:"field_layout,omitempty"`
+	MakerVersion string   `json:"maker_version,omitempty"`
+}
+
+// Info is the header info and the build info of the xdb
+type Info struct {
+	Version     uint16
+	IndexPolicy IndexPolicy
+	IPVersion   IPVersion
+	Format      Format
+	CreatedAt   time.Time
+	FieldLayout []string
+
+	// the xdb does not cover the whole ip space
+	Partial bool
+
+	// size of the xdb data
+	Size int64
+
+	// nil for the xdb files made without the metadata section
+	Metadata *Metadata
+}
+
+// Info return the header info and the build info of the xdb,
+// the metadata section is read on every call
+func (s *Searcher) Info() (*Info, error) {
+	var info = &Info{
+		Version:     s.header.Version,
+		IndexPolicy: s.header.IndexPolicy,
+		IPVersion:   s.header.IPVersion,
+		Format:      s.header.Format,
+		CreatedAt:   time.Unix(int64(s.header.CreatedAt), 0),
+		FieldLayout: s.header.FieldLayout,
+		Partial:     s.header.IsPartial(),
+		Size:        s.size,
+	}
+
+	metadata, err := s.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	info.Metadata = metadata
+	return info, nil
+}
+
+// Metadata load the build info recorded by the maker,
+// nil is returned if the xdb is made without it
+func (s *Searcher) Metadata() (*Metadata, error) {
+	if s.header.MetadataLength == 0 {
+		return nil, nil
+	}
+
+	var ioCount int
+	var buff = make([]byte, s.header.MetadataLength)
+	err := s.read(int64(s.header.MetadataPtr), buff, &ioCount)
+	if err != nil {
+		return nil, fmt.Errorf("read metadata at %d: %w", s.header.MetadataPtr, err)
+	}
+
+	var metadata = &Metadata{}
+	err = json.Unmarshal(buff, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,65 @@
+package xdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendTestMetadata append the metadata block to the fixture db as the maker does
+func appendTestMetadata(t *testing.T, buff []byte, metadata *Metadata) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(metadata)
+	require.NoError(t, err)
+
+	binary.LittleEndian.PutUint32(buff[24:], uint32(len(buff)))
+	binary.LittleEndian.PutUint32(buff[28:], uint32(len(data)))
+	return append(buff, data...)
+}
+
+func TestSearcherInfo(t *testing.T) {
+	metadata := &Metadata{
+		DataVersion:  "20221001",
+		Description:  "test data",
+		SourceFile:   "ip.merge.txt",
+		SourceSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		SegmentCount: len(testSegments),
+		HeadCount:    4,
+		TailCount:    4,
+		FieldLayout:  []string{"country", "province", "city", "isp"},
+		MakerVersion: "test",
+	}
+	buff := appendTestMetadata(t, buildTestDb(t, testSegments), metadata)
+
+	searcher, err := NewWithBuffer(buff)
+	require.NoError(t, err)
+	require.NoError(t, searcher.GetHeader().Validate(int64(len(buff))))
+
+	info, err := searcher.Info()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(VersionNo), info.Version)
+	assert.Equal(t, IPv4, info.IPVersion)
+	assert.Equal(t, int64(1666666666), info.CreatedAt.Unix())
+	assert.Equal(t, int64(len(buff)), info.Size)
+	assert.Equal(t, metadata, info.Metadata)
+
+	// searches are not affected by the metadata block
+	region, err := searcher.SearchByStr("2.12.133.0")
+	require.NoError(t, err)
+	assert.Equal(t, "中国|广东省|深圳市|电信", region)
+}
+
+func TestSearcherInfoWithoutMetadata(t *testing.T) {
+	searcher, err := NewWithFileOnly(writeTestDb(t, testSegments))
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	info, err := searcher.Info()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"country", "province", "city", "isp"}, info.FieldLayout)
+	assert.Nil(t, info.Metadata)
+}
@@ -0,0 +1,359 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// iterate all the segments stored in the ipv4 or ipv6 xdb.
+// the vector slots and their segment index blocks are walked in address order,
+// and the per-/16 splits made by the maker are merged back into the original ranges.
+// the btree segment index and the original xdb one with the full ips are walked
+// from the StartIndexPtr to the EndIndexPtr directly.
+// @Note the adjacent source segments with the same region are merged too,
+// which is the same as the merged source file the maker expects.
+
+package xdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// Segment is an ip range stored in the xdb and its region,
+// the long ips StartIP and EndIP are set for the ipv4 xdb only
+type Segment struct {
+	StartIP    uint32
+	EndIP      uint32
+	StartAddr  netip.Addr
+	EndAddr    netip.Addr
+	RegionHead string
+	RegionTail string
+}
+
+// Region return the full region string, the same as Search returns
+func (s *Segment) Region() string {
+	return s.RegionHead + REGION_STR_SEP + s.RegionTail
+}
+
+// String return the segment as the source line `startIP|endIP|region`
+func (s *Segment) String() string {
+	return s.StartAddr.String() + REGION_STR_SEP + s.EndAddr.String() + REGION_STR_SEP + s.Region()
+}
+
+// SegmentIterator walk the segments of the xdb in address order, eg:
+//
+//	it := searcher.NewSegmentIterator()
+//	for it.Next() {
+//		seg := it.Segment()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+//
+// the iterator is not safe for concurrent use, while the searcher could
+// keep searching during the iteration.
+type SegmentIterator struct {
+	searcher *Searcher
+	ioCount  int
+
+	vectorIndex []byte
+
+	// the next vector slot to load, and the segment index block of the loaded slot
+	slot     int
+	slotHead uint32
+	block    []byte
+
+	// the next btree or original segment index block to load, and the read buffer of the blocks
+	indexPtr uint32
+	buff     []byte
+
+	// the raw segment being merged, and the merged one returned by Segment
+	pending *rawSegment
+	current *Segment
+	done    bool
+	err     error
+
+	// regions cached by region ptr
+	regions map[int64]*Segment
+}
+
+// rawSegment is a decoded segment index entry
+type rawSegment struct {
+	startIP   netip.Addr
+	endIP     netip.Addr
+	regionPtr int64
+}
+
+// NewSegmentIterator create an iterator of all the segments in the xdb
+func (s *Searcher) NewSegmentIterator() *SegmentIterator {
+	return &SegmentIterator{
+		searcher: s,
+		indexPtr: s.header.StartIndexPtr,
+		regions:  map[int64]*Segment{},
+	}
+}
+
+// Next advance the iterator to the next segment,
+// it returns false when all the segments are walked or any error happened
+func (it *SegmentIterator) Next() bool {
+	if it.err != nil || it.done {
+		return false
+	}
+
+	for {
+		raw, ok := it.nextRaw()
+		if it.err != nil {
+			return false
+		}
+
+		if !ok {
+			it.done = true
+			if it.pending == nil {
+				return false
+			}
+
+			return it.emit(nil)
+		}
+
+		// merge the split of the same segment
+		if p := it.pending; p != nil && p.regionPtr == raw.regionPtr && p.endIP.Next() == raw.startIP {
+			p.endIP = raw.endIP
+			continue
+		}
+
+		if it.pending == nil {
+			it.pending = raw
+			continue
+		}
+
+		return it.emit(raw)
+	}
+}
+
+// emit resolve the region of the pending segment as the current one and pend the next
+func (it *SegmentIterator) emit(next *rawSegment) bool {
+	region, err := it.region(it.pending.regionPtr)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	it.current = &Segment{
+		StartAddr:  it.pending.startIP,
+		EndAddr:    it.pending.endIP,
+		RegionHead: region.RegionHead,
+		RegionTail: region.RegionTail,
+	}
+	if it.pending.startIP.Is4() {
+		it.current.StartIP = addrToLong(it.pending.startIP)
+		it.current.EndIP = addrToLong(it.pending.endIP)
+	}
+	it.pending = next
+	return true
+}
+
+// Segment return the current segment
+func (it *SegmentIterator) Segment() *Segment {
+	return it.current
+}
+
+// Err return the error stopped the iteration, if any
+func (it *SegmentIterator) Err() error {
+	return it.err
+}
+
+// nextRaw return the next segment index entry in address order
+func (it *SegmentIterator) nextRaw() (*rawSegment, bool) {
+	var header = it.searcher.header
+	var btree = header.IndexPolicy == BTreeIndexPolicy || header.Format == UpstreamFormat
+	for len(it.block) == 0 {
+		if btree {
+			if it.indexPtr > it.searcher.header.EndIndexPtr {
+				return nil, false
+			}
+
+			err := it.loadBTreeBlocks()
+			if err != nil {
+				it.err = err
+				return nil, false
+			}
+			continue
+		}
+
+		if it.slot >= VectorIndexRows*VectorIndexCols {
+			return nil, false
+		}
+
+		err := it.loadSlot(it.slot)
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		it.slot++
+	}
+
+	var raw = &rawSegment{}
+	switch {
+	case btree && header.IPVersion == IPv6:
+		raw.startIP = netip.AddrFrom16(*(*[16]byte)(it.block[:16]))
+		raw.endIP = netip.AddrFrom16(*(*[16]byte)(it.block[16:32]))
+		raw.regionPtr = int64(binary.LittleEndian.Uint32(it.block[32:]))
+	case btree:
+		raw.startIP = Long2Addr(binary.LittleEndian.Uint32(it.block))
+		raw.endIP = Long2Addr(binary.LittleEndian.Uint32(it.block[4:]))
+		if header.Format == UpstreamFormat {
+			raw.regionPtr = upstreamRegionPtr(it.block)
+		} else {
+			raw.regionPtr = int64(binary.LittleEndian.Uint32(it.block[8:]))
+		}
+	case header.IPVersion == IPv6:
+		// the pre-two bytes of the slot and the ip tails
+		var sip, eip [16]byte
+		binary.BigEndian.PutUint16(sip[:], uint16(it.slotHead>>16))
+		binary.BigEndian.PutUint16(eip[:], uint16(it.slotHead>>16))
+		copy(sip[2:], it.block[:IPv6TailLength])
+		copy(eip[2:], it.block[IPv6TailLength:IPv6TailLength*2])
+		raw.startIP, raw.endIP = netip.AddrFrom16(sip), netip.AddrFrom16(eip)
+		raw.regionPtr = int64(binary.LittleEndian.Uint32(it.block[IPv6TailLength*2:]))
+	default:
+		raw.startIP = Long2Addr(it.slotHead | uint32(binary.LittleEndian.Uint16(it.block)))
+		raw.endIP = Long2Addr(it.slotHead | uint32(binary.LittleEndian.Uint16(it.block[2:])))
+		raw.regionPtr = int64(binary.LittleEndian.Uint32(it.block[4:]))
+	}
+
+	it.block = it.block[header.IndexBlockSize():]
+	return raw, true
+}
+
+// loadSlot load the whole segment index block of the vector slot with one read
+func (it *SegmentIterator) loadSlot(slot int) error {
+	var s = it.searcher
+	if it.vectorIndex == nil {
+		err := it.loadVectorIndex()
+		if err != nil {
+			return err
+		}
+	}
+
+	var idx = slot * VectorIndexSize
+	var sPtr = binary.LittleEndian.Uint32(it.vectorIndex[idx:])
+	var ePtr = binary.LittleEndian.Uint32(it.vectorIndex[idx+4:])
+	var blockSize = s.header.IndexBlockSize()
+	it.slotHead = uint32(slot) << 16
+	it.block = nil
+
+	// empty slot, not covered by the xdb
+	if sPtr == 0 && ePtr == 0 {
+		return nil
+	}
+
+	if ePtr < sPtr || (ePtr-sPtr)%blockSize != 0 {
+		return fmt.Errorf("%w: invalid vector index of slot %d: (%d, %d)", ErrCorruptDB, slot, sPtr, ePtr)
+	}
+
+	// the reserved slot is a single index block with the same sPtr and ePtr
+	var length = ePtr - sPtr
+	if length == 0 {
+		length = blockSize
+	}
+
+	if cBuff := s.contentBuff; cBuff != nil && int64(sPtr)+int64(length) <= int64(len(cBuff)) {
+		it.block = cBuff[sPtr : sPtr+length]
+		return nil
+	}
+
+	var block = make([]byte, length)
+	err := s.read(int64(sPtr), block, &it.ioCount)
+	if err != nil {
+		return fmt.Errorf("read segment index block at %d: %w", sPtr, err)
+	}
+
+	it.block = block
+	return nil
+}
+
+// the max btree segment index blocks loaded with one read
+const btreeIterateBlocks = 4096
+
+// loadBTreeBlocks load the next btree or original segment index blocks with one read
+func (it *SegmentIterator) loadBTreeBlocks() error {
+	var s = it.searcher
+	var blockSize = s.header.IndexBlockSize()
+	var count = (s.header.EndIndexPtr-it.indexPtr)/blockSize + 1
+	if count > btreeIterateBlocks {
+		count = btreeIterateBlocks
+	}
+
+	if it.buff == nil && s.contentBuff == nil {
+		it.buff = make([]byte, btreeIterateBlocks*blockSize)
+	}
+
+	var ptr = it.indexPtr
+	var length = count * blockSize
+	block, err := s.view(int64(ptr), int(length), it.buff, &it.ioCount)
+	if err != nil {
+		return fmt.Errorf("read segment index block at %d: %w", ptr, err)
+	}
+
+	it.indexPtr += length
+	it.block = block
+	return nil
+}
+
+// loadVectorIndex use the loaded vector index or read the whole of it once
+func (it *SegmentIterator) loadVectorIndex() error {
+	var s = it.searcher
+	if vectorIndex := s.vectorIndex.Load(); vectorIndex != nil {
+		it.vectorIndex = *vectorIndex
+		return nil
+	}
+
+	if s.contentBuff != nil && len(s.contentBuff) >= HeaderInfoLength+VectorIndexLength {
+		it.vectorIndex = s.contentBuff[HeaderInfoLength : HeaderInfoLength+VectorIndexLength]
+		return nil
+	}
+
+	var vectorIndex = make([]byte, VectorIndexLength)
+	err := s.read(HeaderInfoLength, vectorIndex, &it.ioCount)
+	if err != nil {
+		return fmt.Errorf("read vector index: %w", err)
+	}
+
+	it.vectorIndex = vectorIndex
+	return nil
+}
+
+// region load the full region of the region ptr, with the regions memoised
+func (it *SegmentIterator) region(regionPtr int64) (*Segment, error) {
+	if region, has := it.regions[regionPtr]; has {
+		return region, nil
+	}
+
+	var s = it.searcher
+	if s.header.Format == UpstreamFormat {
+		var lk = getLookup()
+		buff, err := s.appendUpstreamRegion(nil, regionPtr, lk, &it.ioCount)
+		putLookup(lk)
+		if err != nil {
+			return nil, err
+		}
+
+		var region = &Segment{}
+		region.RegionHead, region.RegionTail = splitUpstreamRegion(string(buff))
+		it.regions[regionPtr] = region
+		return region, nil
+	}
+
+	headOffset, tail, err := s.readRegionTail(regionPtr, true, &it.ioCount)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := s.readRegionHead(headOffset, &it.ioCount)
+	if err != nil {
+		return nil, err
+	}
+
+	var region = &Segment{RegionHead: string(head), RegionTail: string(tail)}
+	it.regions[regionPtr] = region
+	return region, nil
+}
@@ -0,0 +1,81 @@
+package xdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectSegments walk all the segments as the source lines of the fixture
+func collectSegments(t *testing.T, searcher *Searcher) []testSegment {
+	t.Helper()
+
+	var segments []testSegment
+	it := searcher.NewSegmentIterator()
+	for it.Next() {
+		seg := it.Segment()
+		segments = append(segments, testSegment{seg.StartAddr.String(), seg.EndAddr.String(), seg.RegionHead, seg.RegionTail})
+	}
+	require.NoError(t, it.Err())
+	return segments
+}
+
+func TestSegmentIterator(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegments, testSegmentsReserved} {
+		dbPath := writeTestDb(t, segments)
+		for name, policy := range testCachePolicies {
+			t.Run(name, func(t *testing.T) {
+				searcher, err := Create(dbPath, policy)
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				assert.Equal(t, segments, collectSegments(t, searcher))
+			})
+		}
+	}
+}
+
+func TestSegmentIteratorReserved(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsReserved))
+	require.NoError(t, err)
+
+	region, err := searcher.SearchByStr("10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, "0|0|内网IP|内网IP", region)
+
+	it := searcher.NewSegmentIterator()
+	require.True(t, it.Next())
+	assert.Equal(t, "0.0.0.0|0.255.255.255|0|0|内网IP|内网IP", it.Segment().String())
+}
+
+func TestSegmentIteratorV6(t *testing.T) {
+	for _, segments := range [][]testSegment{testSegmentsV6, testSegmentsPartialV6} {
+		for _, policy := range []IndexPolicy{VectorIndexPolicy, BTreeIndexPolicy} {
+			dbPath := writeTestFile(t, buildTestDbWithPolicy(t, segments, policy))
+			for name, cachePolicy := range testCachePolicies {
+				t.Run(policy.String()+"/"+name, func(t *testing.T) {
+					searcher, err := Create(dbPath, cachePolicy)
+					require.NoError(t, err)
+					defer searcher.Close()
+
+					assert.Equal(t, segments, collectSegments(t, searcher))
+				})
+			}
+		}
+	}
+
+	// the long ips are left zero for the ipv6 segments
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsV6))
+	require.NoError(t, err)
+	it := searcher.NewSegmentIterator()
+	require.True(t, it.Next())
+	assert.Zero(t, it.Segment().EndIP)
+	assert.Equal(t, "::|2001:250:ffff:ffff:ffff:ffff:ffff:ffff|0|0|0|0", it.Segment().String())
+}
+
+func TestSegmentIteratorPartial(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsPartial))
+	require.NoError(t, err)
+	assert.Equal(t, testSegmentsPartial, collectSegments(t, searcher))
+}
@@ -0,0 +1,21 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package xdb
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func mmap(handle *os.File, size int64) ([]byte, error) {
+	return nil, fmt.Errorf("mmap is not supported on %s", runtime.GOOS)
+}
+
+func munmap(buff []byte) error {
+	return fmt.Errorf("mmap is not supported on %s", runtime.GOOS)
+}
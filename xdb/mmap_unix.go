@@ -0,0 +1,22 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package xdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap map the whole file read only and shared,
+// so the pages are shared with all the processes mapping the same file
+func mmap(handle *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(handle.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(buff []byte) error {
+	return syscall.Munmap(buff)
+}
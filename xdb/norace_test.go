@@ -0,0 +1,5 @@
+//go:build !race
+
+package xdb
+
+const raceEnabled = false
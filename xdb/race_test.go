@@ -0,0 +1,6 @@
+//go:build race
+
+package xdb
+
+// sync.Pool drops the items randomly with the race detector, so the alloc counts are not stable
+const raceEnabled = true
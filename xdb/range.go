@@ -0,0 +1,183 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// search with the matched ip range.
+// the range is the segment of the segment index block matched by the ip:
+// the vector index splits the segments with the pre-two bytes of the ip, so the range
+// never crosses the /16 boundary, while the btree index keeps the full segments.
+
+package xdb
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// IPRange is a matched ip range and its region,
+// the long ips StartIP and EndIP are set for the ipv4 range only
+type IPRange struct {
+	StartIP   uint32
+	EndIP     uint32
+	StartAddr netip.Addr
+	EndAddr   netip.Addr
+	Region    string
+}
+
+// bounds return the start and end address, converted from the long ips if not set
+func (r *IPRange) bounds() (netip.Addr, netip.Addr) {
+	if r.StartAddr.IsValid() {
+		return r.StartAddr, r.EndAddr
+	}
+
+	return Long2Addr(r.StartIP), Long2Addr(r.EndIP)
+}
+
+// Contains check if the long ip is inside the ipv4 range
+func (r *IPRange) Contains(ip uint32) bool {
+	return ip >= r.StartIP && ip <= r.EndIP && !r.StartAddr.Is6()
+}
+
+// ContainsAddr check if the address is inside the range
+func (r *IPRange) ContainsAddr(addr netip.Addr) bool {
+	sip, eip := r.bounds()
+	return addr.Compare(sip) >= 0 && addr.Compare(eip) <= 0
+}
+
+// Prefixes return the minimal CIDR blocks covering the range
+func (r *IPRange) Prefixes() []netip.Prefix {
+	return AddrRangeToPrefixes(r.bounds())
+}
+
+// String return the range as `startIP|endIP|region`
+func (r *IPRange) String() string {
+	sip, eip := r.bounds()
+	return sip.String() + REGION_STR_SEP + eip.String() + REGION_STR_SEP + r.Region
+}
+
+// RangeToPrefixes return the minimal CIDR blocks covering the ipv4 range [sip, eip]
+func RangeToPrefixes(sip uint32, eip uint32) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for cur := uint64(sip); cur <= uint64(eip); {
+		// the largest block aligned at cur and not beyond eip
+		var hostBits = 32
+		if cur != 0 {
+			hostBits = bits.TrailingZeros32(uint32(cur))
+		}
+		for hostBits > 0 && cur+(uint64(1)<<hostBits)-1 > uint64(eip) {
+			hostBits--
+		}
+
+		prefixes = append(prefixes, netip.PrefixFrom(Long2Addr(uint32(cur)), 32-hostBits))
+		cur += uint64(1) << hostBits
+	}
+
+	return prefixes
+}
+
+// AddrRangeToPrefixes return the minimal CIDR blocks covering the range [sip, eip]
+// of the same ip version
+func AddrRangeToPrefixes(sip netip.Addr, eip netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for cur := sip; cur.IsValid() && cur.Compare(eip) <= 0; {
+		// the largest block aligned at cur and not beyond eip
+		var prefix = netip.PrefixFrom(cur, cur.BitLen())
+		for bits := cur.BitLen() - 1; bits >= 0; bits-- {
+			p := netip.PrefixFrom(cur, bits)
+			if p.Masked().Addr() != cur || prefixLastAddr(p).Compare(eip) > 0 {
+				break
+			}
+			prefix = p
+		}
+
+		prefixes = append(prefixes, prefix)
+		// the Next of the max ip is invalid
+		cur = prefixLastAddr(prefix).Next()
+	}
+
+	return prefixes
+}
+
+// prefixLastAddr return the last address of the prefix
+func prefixLastAddr(p netip.Prefix) netip.Addr {
+	var ip = p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(ip)*8; i++ {
+		ip[i/8] |= 0x80 >> (i % 8)
+	}
+
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr
+}
+
+// SearchRangeByStr find the region and the matched range for the specified ip string,
+// the ip string should be an ipv4 or ipv6 address according to the ip version of the xdb
+func (s *Searcher) SearchRangeByStr(str string) (*IPRange, error) {
+	if s.header.IPVersion == IPv6 {
+		ip, err := CheckIPv6(str)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.SearchRangeV6(ip)
+	}
+
+	ip, err := CheckIP(str)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SearchRange(ip)
+}
+
+// SearchRange find the region and the matched range for the specified long ip
+func (s *Searcher) SearchRange(ip uint32) (*IPRange, error) {
+	var ioCount int
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	regionPtr, startIP, endIP, err := s.locate(ip, lk, &ioCount)
+	if err != nil {
+		s.ioCount.Store(int64(ioCount))
+		return nil, err
+	}
+
+	lk.result, err = s.appendRegion(lk.result[:0], regionPtr, lk, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPRange{
+		StartIP:   startIP,
+		EndIP:     endIP,
+		StartAddr: Long2Addr(startIP),
+		EndAddr:   Long2Addr(endIP),
+		Region:    string(lk.result),
+	}, nil
+}
+
+// SearchRangeV6 find the region and the matched range for the specified 16 bytes ipv6 address
+func (s *Searcher) SearchRangeV6(ip [16]byte) (*IPRange, error) {
+	var ioCount int
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	regionPtr, startIP, endIP, err := s.locateV6(ip, lk, &ioCount)
+	if err != nil {
+		s.ioCount.Store(int64(ioCount))
+		return nil, err
+	}
+
+	lk.result, err = s.appendRegion(lk.result[:0], regionPtr, lk, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPRange{
+		StartAddr: netip.AddrFrom16(startIP),
+		EndAddr:   netip.AddrFrom16(endIP),
+		Region:    string(lk.result),
+	}, nil
+}
@@ -0,0 +1,116 @@
+package xdb
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchRange(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			r, err := searcher.SearchRangeByStr("2.12.136.1")
+			require.NoError(t, err)
+			assert.Equal(t, "2.12.134.0|2.12.139.255|法国|Ille-et-Vilaine|0|橘子电信", r.String())
+			assert.Equal(t, []netip.Prefix{
+				netip.MustParsePrefix("2.12.134.0/23"),
+				netip.MustParsePrefix("2.12.136.0/22"),
+			}, r.Prefixes())
+
+			// the range is split with the /16 boundary
+			r, err = searcher.SearchRangeByStr("2.13.0.1")
+			require.NoError(t, err)
+			assert.Equal(t, "2.13.0.0|2.13.0.255|法国|0|0|橘子电信", r.String())
+			assert.True(t, r.Contains(0x020D00FF))
+			assert.False(t, r.Contains(0x020D0100))
+		})
+	}
+}
+
+func TestSearchRangeV6(t *testing.T) {
+	for policy, expect := range map[IndexPolicy]string{
+		VectorIndexPolicy: "2001:251::1:0|2001:ffff:ffff:ffff:ffff:ffff:ffff:ffff|美国|0|0|0",
+		BTreeIndexPolicy:  "2001:251::1:0|2400:ffff:ffff:ffff:ffff:ffff:ffff:ffff|美国|0|0|0",
+	} {
+		dbPath := writeTestFile(t, buildTestDbWithPolicy(t, testSegmentsV6, policy))
+		for name, cachePolicy := range testCachePolicies {
+			t.Run(policy.String()+"/"+name, func(t *testing.T) {
+				searcher, err := Create(dbPath, cachePolicy)
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				r, err := searcher.SearchRangeByStr("2001:251::1:5")
+				require.NoError(t, err)
+				assert.Equal(t, expect, r.String())
+				assert.Zero(t, r.StartIP)
+				assert.True(t, r.ContainsAddr(netip.MustParseAddr("2001:251::ffff:0")))
+				assert.False(t, r.ContainsAddr(netip.MustParseAddr("2001:251::ffff")))
+
+				r, err = searcher.SearchRangeByStr("2001:251::1")
+				require.NoError(t, err)
+				assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("2001:251::/112")}, r.Prefixes())
+
+				_, err = searcher.SearchRangeByStr("1.2.3.4")
+				assert.ErrorIs(t, err, ErrInvalidIP)
+				_, err = searcher.SearchRange(0x01020304)
+				assert.ErrorIs(t, err, ErrIPVersionMismatch)
+			})
+		}
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	cases := []struct {
+		sip, eip string
+		expect   []string
+	}{
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"1.2.3.4", "1.2.3.4", []string{"1.2.3.4/32"}},
+		{"1.0.0.1", "1.0.0.6", []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}},
+		{"255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+	}
+	cases6 := []struct {
+		sip, eip string
+		expect   []string
+	}{
+		{"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"::/0"}},
+		{"2001:251::2:0", "2001:251::3:ffff", []string{"2001:251::2:0/111"}},
+		{"2001:251::1", "2001:251::6", []string{"2001:251::1/128", "2001:251::2/127", "2001:251::4/127", "2001:251::6/128"}},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"}},
+	}
+
+	for _, c := range cases {
+		sip, err := CheckIP(c.sip)
+		require.NoError(t, err)
+		eip, err := CheckIP(c.eip)
+		require.NoError(t, err)
+
+		var prefixes []string
+		for _, p := range RangeToPrefixes(sip, eip) {
+			prefixes = append(prefixes, p.String())
+		}
+		assert.Equal(t, c.expect, prefixes)
+
+		// the same blocks with the addresses
+		prefixes = nil
+		for _, p := range AddrRangeToPrefixes(Long2Addr(sip), Long2Addr(eip)) {
+			prefixes = append(prefixes, p.String())
+		}
+		assert.Equal(t, c.expect, prefixes)
+	}
+
+	for _, c := range cases6 {
+		var prefixes []string
+		for _, p := range AddrRangeToPrefixes(netip.MustParseAddr(c.sip), netip.MustParseAddr(c.eip)) {
+			prefixes = append(prefixes, p.String())
+		}
+		assert.Equal(t, c.expect, prefixes)
+	}
+}
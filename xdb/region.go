@@ -0,0 +1,91 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+package xdb
+
+import (
+	"strings"
+)
+
+// --- region field define
+// the field names are used in the field layout recorded in the xdb header
+
+const (
+	REGION_FIELD_COUNTRY  = "country"
+	REGION_FIELD_AREA     = "area"
+	REGION_FIELD_PROVINCE = "province"
+	REGION_FIELD_CITY     = "city"
+	REGION_FIELD_ISP      = "isp"
+
+	// placeholder of the empty field in the source file
+	REGION_EMPTY_FIELD = "0"
+)
+
+// default field layouts for the xdb files made before the field layout recorded in the header
+var (
+	legacyFullFieldLayout   = []string{REGION_FIELD_COUNTRY, REGION_FIELD_AREA, REGION_FIELD_PROVINCE, REGION_FIELD_CITY, REGION_FIELD_ISP}
+	legacyNoAreaFieldLayout = []string{REGION_FIELD_COUNTRY, REGION_FIELD_PROVINCE, REGION_FIELD_CITY, REGION_FIELD_ISP}
+)
+
+// Region is the structured region info of a search
+type Region struct {
+	Country  string
+	Area     string
+	Province string
+	City     string
+	ISP      string
+
+	// the fields behind the known ones, eg: custom extension of the source file
+	Extra []string
+
+	// the raw region string returned by Search
+	Raw string
+}
+
+// ParseRegion map the raw region string to a Region with the specified field layout,
+// the empty placeholder "0" is converted to an empty string.
+// an empty field layout means it is unknown, and the layout is guessed with the field count.
+func ParseRegion(raw string, fieldLayout []string) *Region {
+	var region = &Region{Raw: raw}
+	var fields = strings.Split(raw, REGION_STR_SEP)
+	if len(fieldLayout) == 0 {
+		fieldLayout = legacyFullFieldLayout
+		if len(fields) == len(legacyNoAreaFieldLayout) {
+			fieldLayout = legacyNoAreaFieldLayout
+		}
+	}
+
+	for i, field := range fields {
+		if field == REGION_EMPTY_FIELD {
+			field = ""
+		}
+
+		if i >= len(fieldLayout) {
+			region.Extra = append(region.Extra, field)
+			continue
+		}
+
+		switch fieldLayout[i] {
+		case REGION_FIELD_COUNTRY:
+			region.Country = field
+		case REGION_FIELD_AREA:
+			region.Area = field
+		case REGION_FIELD_PROVINCE:
+			region.Province = field
+		case REGION_FIELD_CITY:
+			region.City = field
+		case REGION_FIELD_ISP:
+			region.ISP = field
+		default:
+			region.Extra = append(region.Extra, field)
+		}
+	}
+
+	return region
+}
+
+// String return the raw region string
+func (r *Region) String() string {
+	return r.Raw
+}
@@ -0,0 +1,37 @@
+package xdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegion(t *testing.T) {
+	region := ParseRegion("法国|0|Ille-et-Vilaine|0|橘子电信", legacyFullFieldLayout)
+	assert.Equal(t, &Region{
+		Country:  "法国",
+		Province: "Ille-et-Vilaine",
+		ISP:      "橘子电信",
+		Raw:      "法国|0|Ille-et-Vilaine|0|橘子电信",
+	}, region)
+
+	// custom extension fields of the tail
+	region = ParseRegion("中国|广东省|深圳市|电信|AS4134|Asia/Shanghai", legacyNoAreaFieldLayout)
+	assert.Equal(t, "广东省", region.Province)
+	assert.Equal(t, "深圳市", region.City)
+	assert.Equal(t, "电信", region.ISP)
+	assert.Equal(t, []string{"AS4134", "Asia/Shanghai"}, region.Extra)
+}
+
+func TestParseRegionWithoutLayout(t *testing.T) {
+	// 5 fields: country|area|province|city|isp
+	region := ParseRegion("中国|华南|广东省|深圳市|电信", nil)
+	assert.Equal(t, "华南", region.Area)
+	assert.Equal(t, "广东省", region.Province)
+
+	// 4 fields: country|province|city|isp
+	region = ParseRegion("中国|广东省|深圳市|电信", nil)
+	assert.Equal(t, "", region.Area)
+	assert.Equal(t, "广东省", region.Province)
+	assert.Equal(t, "电信", region.ISP)
+}
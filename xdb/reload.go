@@ -0,0 +1,478 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// reloadable searcher.
+// the xdb file could be switched without restarting, by calling Reload,
+// by watching the file changes or by watching the signals.
+// the searches take a reference of the current searcher without locking,
+// the searches in flight finish on the old searcher, and the old searcher
+// is closed by the last of them.
+// @Note replace the xdb file with a rename instead of rewriting it in place,
+// especially for the CACHE_POLICY_MMAP.
+
+package xdb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadedSearcher is a loaded xdb with the reference count of its users,
+// the ReloadableSearcher holds one reference until it switches to another
+type loadedSearcher struct {
+	searcher *Searcher
+	refs     atomic.Int64
+}
+
+// acquire a reference, it fails once all the references released
+func (l *loadedSearcher) acquire() bool {
+	for {
+		n := l.refs.Load()
+		if n == 0 {
+			return false
+		}
+
+		if l.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release a reference, the searcher is closed with the last one
+func (l *loadedSearcher) release() {
+	if l.refs.Add(-1) == 0 {
+		l.searcher.Close()
+	}
+}
+
+type ReloadableSearcher struct {
+	dbPath      string
+	cachePolicy CachePolicy
+	setups      []func(*Searcher)
+
+	// the current searcher, switched atomically
+	current atomic.Pointer[loadedSearcher]
+
+	// serialize the reloads, and guard the loaded file info
+	reloadLock sync.Mutex
+	modTime    time.Time
+	size       int64
+	closed     bool
+
+	// error handler for the reloads triggered by the watchers
+	errorHandler atomic.Pointer[func(error)]
+
+	// callbacks after every successful switch, guarded by reloadLock
+	reloadHandlers []func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReloadableSearcher create a reloadable searcher of the xdb file with the cache policy,
+// the setups are applied to every loaded searcher before it is used, eg: SetSearchMode.
+func NewReloadableSearcher(dbPath string, cachePolicy CachePolicy, setups ...func(*Searcher)) (*ReloadableSearcher, error) {
+	r := &ReloadableSearcher{
+		dbPath:      dbPath,
+		cachePolicy: cachePolicy,
+		setups:      setups,
+		stop:        make(chan struct{}),
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// OnReloadError set the handler of the errors of the reloads triggered by WatchFile or WatchSignal
+func (r *ReloadableSearcher) OnReloadError(handler func(error)) *ReloadableSearcher {
+	r.errorHandler.Store(&handler)
+	return r
+}
+
+// OnReload add a callback called after every successful switch, eg: purge the result cache
+func (r *ReloadableSearcher) OnReload(handler func()) {
+	r.reloadLock.Lock()
+	r.reloadHandlers = append(r.reloadHandlers, handler)
+	r.reloadLock.Unlock()
+}
+
+// Reload load the xdb file and switch to it, the file is validated first,
+// and the current searcher is kept if it is invalid.
+func (r *ReloadableSearcher) Reload() error {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+	return r.reload()
+}
+
+func (r *ReloadableSearcher) reload() error {
+	if r.closed {
+		return fmt.Errorf("reload a closed searcher")
+	}
+
+	// the file info is taken from the opened handle,
+	// so it describes the file loaded even if the file is replaced meanwhile
+	handle, err := os.Open(r.dbPath)
+	if err != nil {
+		return fmt.Errorf("open xdb file `%s`: %w", r.dbPath, err)
+	}
+
+	fi, err := handle.Stat()
+	if err != nil {
+		_ = handle.Close()
+		return fmt.Errorf("stat xdb file `%s`: %w", r.dbPath, err)
+	}
+
+	searcher, err := r.load(handle, fi.Size())
+	if err != nil {
+		return fmt.Errorf("reload xdb file `%s`: %w", r.dbPath, err)
+	}
+
+	// the old one is closed after all the searches on it finished
+	loaded := &loadedSearcher{searcher: searcher}
+	loaded.refs.Store(1)
+	if old := r.current.Swap(loaded); old != nil {
+		old.release()
+	}
+
+	r.modTime, r.size = fi.ModTime(), fi.Size()
+	for _, handler := range r.reloadHandlers {
+		handler()
+	}
+
+	return nil
+}
+
+// load and validate the opened xdb file, the handle is taken over
+func (r *ReloadableSearcher) load(handle *os.File, size int64) (*Searcher, error) {
+	searcher, err := createWithFile(handle, size, r.cachePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	// the checksums are verified as well, a partially synced file is rejected
+	searcher, err = searcher.apply([]Option{WithVerify()})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, setup := range r.setups {
+		setup(searcher)
+	}
+
+	// try a search to make sure the searcher works,
+	// the partial xdb may not cover the zero ip
+	if searcher.header.IPVersion == IPv6 {
+		_, err = searcher.SearchV6([16]byte{})
+	} else {
+		_, err = searcher.Search(0)
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		searcher.Close()
+		return nil, fmt.Errorf("test search: %w", err)
+	}
+
+	return searcher, nil
+}
+
+// WatchFile check the xdb file every interval, and reload it once the modify time or size changed
+func (r *ReloadableSearcher) WatchFile(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.reloadIfChanged()
+			}
+		}
+	}()
+}
+
+func (r *ReloadableSearcher) reloadIfChanged() {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	fi, err := os.Stat(r.dbPath)
+	if err != nil {
+		r.handleError(fmt.Errorf("stat xdb file `%s`: %w", r.dbPath, err))
+		return
+	}
+
+	if fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return
+	}
+
+	// the file info is not updated on failure, so it is retried on the next check
+	err = r.reload()
+	if err != nil {
+		r.handleError(err)
+	}
+}
+
+// WatchSignal reload the xdb file once any of the signals received, eg: syscall.SIGHUP
+func (r *ReloadableSearcher) WatchSignal(sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ch:
+				err := r.Reload()
+				if err != nil {
+					r.handleError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *ReloadableSearcher) handleError(err error) {
+	if handler := r.errorHandler.Load(); handler != nil && *handler != nil {
+		(*handler)(err)
+	}
+}
+
+// acquire a reference of the current searcher, release it once the search finished
+func (r *ReloadableSearcher) acquire() (*loadedSearcher, error) {
+	for {
+		loaded := r.current.Load()
+		if loaded.acquire() {
+			return loaded, nil
+		}
+
+		// released by the switch, retry with the new one
+		if r.current.Load() == loaded {
+			return nil, ErrClosed
+		}
+	}
+}
+
+// With run fn with the current searcher, the searcher is not closed until fn returns.
+// the searcher should not be used after fn returned.
+func (r *ReloadableSearcher) With(fn func(s *Searcher) error) error {
+	loaded, err := r.acquire()
+	if err != nil {
+		return err
+	}
+
+	defer loaded.release()
+	return fn(loaded.searcher)
+}
+
+// GetHeader return the header info of the current xdb
+func (r *ReloadableSearcher) GetHeader() *Header {
+	return r.current.Load().searcher.header
+}
+
+// Info return the header info and the build info of the current xdb
+func (r *ReloadableSearcher) Info() (*Info, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.Info()
+}
+
+// SearchByStr find the region for the specified ip string with the current xdb
+func (r *ReloadableSearcher) SearchByStr(str string) (string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchByStr(str)
+}
+
+// SearchByStrWithIOCount find the region and the io count for the specified ip string with the current xdb
+func (r *ReloadableSearcher) SearchByStrWithIOCount(str string) (string, int, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchByStrWithIOCount(str)
+}
+
+// Search find the region for the specified long ip with the current xdb
+func (r *ReloadableSearcher) Search(ip uint32) (string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.Search(ip)
+}
+
+// SearchWithIOCount find the region and the io count for the specified long ip with the current xdb
+func (r *ReloadableSearcher) SearchWithIOCount(ip uint32) (string, int, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchWithIOCount(ip)
+}
+
+// SearchRange find the region and the matched range for the specified long ip with the current xdb
+func (r *ReloadableSearcher) SearchRange(ip uint32) (*IPRange, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchRange(ip)
+}
+
+// SearchV6 find the region for the specified 16 bytes ipv6 address with the current xdb
+func (r *ReloadableSearcher) SearchV6(ip [16]byte) (string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchV6(ip)
+}
+
+// AppendSearch append the region of the specified long ip to dst with the current xdb
+func (r *ReloadableSearcher) AppendSearch(dst []byte, ip uint32) ([]byte, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.AppendSearch(dst, ip)
+}
+
+// AppendSearchByStr append the region of the specified ip string to dst with the current xdb
+func (r *ReloadableSearcher) AppendSearchByStr(dst []byte, str string) ([]byte, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.AppendSearchByStr(dst, str)
+}
+
+// SearchByAddr find the region for the specified netip.Addr with the current xdb
+func (r *ReloadableSearcher) SearchByAddr(addr netip.Addr) (string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchByAddr(addr)
+}
+
+// SearchByNetIP find the region for the specified net.IP with the current xdb
+func (r *ReloadableSearcher) SearchByNetIP(ip net.IP) (string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return "", err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchByNetIP(ip)
+}
+
+// SearchRegionByStr find the structured region for the specified ip string with the current xdb
+func (r *ReloadableSearcher) SearchRegionByStr(str string) (*Region, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchRegionByStr(str)
+}
+
+// SearchRegion find the structured region for the specified long ip with the current xdb
+func (r *ReloadableSearcher) SearchRegion(ip uint32) (*Region, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchRegion(ip)
+}
+
+// SearchRangesByRegion find all the ipv4 ranges with the regions matched by the filter with the current xdb
+func (r *ReloadableSearcher) SearchRangesByRegion(filter *RegionFilter) ([]*IPRange, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchRangesByRegion(filter)
+}
+
+// SearchBatch find the regions for the specified long ips with the current xdb
+func (r *ReloadableSearcher) SearchBatch(ips []uint32) ([]string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	regions, _, err := loaded.searcher.SearchBatchWithIOCount(ips)
+	return regions, err
+}
+
+// SearchBatchByStr find the regions for the specified ip strings with the current xdb
+func (r *ReloadableSearcher) SearchBatchByStr(ipList []string) ([]string, error) {
+	loaded, err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	defer loaded.release()
+	return loaded.searcher.SearchBatchByStr(ipList)
+}
+
+// Close stop the watchers and close the current searcher
+func (r *ReloadableSearcher) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+	if r.closed {
+		return
+	}
+
+	// the searcher is closed after the searches in flight finished,
+	// and the following searches fail with ErrClosed
+	r.closed = true
+	r.current.Load().release()
+}
@@ -0,0 +1,175 @@
+package xdb
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSegmentsNext = []testSegment{
+	{"0.0.0.0", "2.12.133.255", "中国|广东省", "广州市|联通"},
+	{"2.12.134.0", "255.255.255.255", "法国|0", "0|橘子电信"},
+}
+
+// replaceTestDb replace the db file with a rename
+func replaceTestDb(t *testing.T, dbPath string, buff []byte) {
+	tmpPath := filepath.Join(filepath.Dir(dbPath), "next.xdb")
+	require.NoError(t, os.WriteFile(tmpPath, buff, 0600))
+	require.NoError(t, os.Rename(tmpPath, dbPath))
+}
+
+func TestReload(t *testing.T) {
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			dbPath := writeTestDb(t, testSegments)
+			searcher, err := NewReloadableSearcher(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			region, err := searcher.SearchByStr("1.1.1.1")
+			require.NoError(t, err)
+			assert.Equal(t, "中国|广东省|深圳市|电信", region)
+
+			// keep searching while reloading
+			var stop atomic.Bool
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for !stop.Load() {
+						region, err := searcher.SearchByStr("1.1.1.1")
+						if err != nil || (region != "中国|广东省|深圳市|电信" && region != "中国|广东省|广州市|联通") {
+							t.Errorf("search during reload: %s, %v", region, err)
+							return
+						}
+					}
+				}()
+			}
+
+			for i := 0; i < 4; i++ {
+				segments := testSegmentsNext
+				if i%2 == 1 {
+					segments = testSegments
+				}
+				replaceTestDb(t, dbPath, buildTestDb(t, segments))
+				require.NoError(t, searcher.Reload())
+			}
+			stop.Store(true)
+			wg.Wait()
+
+			region, err = searcher.SearchByStr("1.1.1.1")
+			require.NoError(t, err)
+			assert.Equal(t, "中国|广东省|深圳市|电信", region)
+		})
+	}
+}
+
+func TestReloadPartial(t *testing.T) {
+	// the partial xdb not covering the zero ip
+	for _, segments := range [][]testSegment{testSegmentsPartial, testSegmentsPartialV6} {
+		for name, policy := range testCachePolicies {
+			t.Run(name, func(t *testing.T) {
+				dbPath := writeTestDb(t, segments[1:])
+				searcher, err := NewReloadableSearcher(dbPath, policy)
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				var seg = segments[1]
+				region, err := searcher.SearchByStr(seg.sip)
+				require.NoError(t, err)
+				assert.Equal(t, seg.head+REGION_STR_SEP+seg.tail, region)
+
+				replaceTestDb(t, dbPath, buildTestDb(t, segments))
+				require.NoError(t, searcher.Reload())
+				seg = segments[0]
+				region, err = searcher.SearchByStr(seg.sip)
+				require.NoError(t, err)
+				assert.Equal(t, seg.head+REGION_STR_SEP+seg.tail, region)
+			})
+		}
+	}
+}
+
+func TestReloadCorruptFile(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	searcher, err := NewReloadableSearcher(dbPath, CACHE_POLICY_MEMORY)
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	// truncated file is refused, and the old data is kept
+	buff := buildTestDb(t, testSegmentsNext)
+	replaceTestDb(t, dbPath, buff[:len(buff)/2])
+	assert.Error(t, searcher.Reload())
+
+	region, err := searcher.SearchByStr("1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "中国|广东省|深圳市|电信", region)
+
+	_, err = NewReloadableSearcher(dbPath, CACHE_POLICY_MEMORY)
+	assert.Error(t, err)
+}
+
+func TestReloadWatchFile(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	searcher, err := NewReloadableSearcher(dbPath, CACHE_POLICY_FILE)
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	var errCount atomic.Int32
+	searcher.OnReloadError(func(err error) {
+		errCount.Add(1)
+	}).WatchFile(10 * time.Millisecond)
+
+	replaceTestDb(t, dbPath, buildTestDb(t, testSegmentsNext))
+	assert.Eventually(t, func() bool {
+		region, err := searcher.SearchByStr("1.1.1.1")
+		return err == nil && region == "中国|广东省|广州市|联通"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), errCount.Load())
+}
+
+func TestReloadInFlight(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	searcher, err := NewReloadableSearcher(dbPath, CACHE_POLICY_FILE)
+	require.NoError(t, err)
+
+	// the switched out searcher keeps working until the search on it finished
+	var old *Searcher
+	err = searcher.With(func(s *Searcher) error {
+		old = s
+		replaceTestDb(t, dbPath, buildTestDb(t, testSegmentsNext))
+		require.NoError(t, searcher.Reload())
+
+		region, err := s.SearchByStr("1.1.1.1")
+		require.NoError(t, err)
+		assert.Equal(t, "中国|广东省|深圳市|电信", region)
+		assert.NotNil(t, s.handle)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Nil(t, old.handle)
+
+	region, err := searcher.SearchByStr("1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "中国|广东省|广州市|联通", region)
+
+	// the file info of the loaded file is recorded
+	fi, err := os.Stat(dbPath)
+	require.NoError(t, err)
+	assert.Equal(t, fi.Size(), searcher.size)
+	assert.True(t, fi.ModTime().Equal(searcher.modTime))
+
+	searcher.Close()
+	_, err = searcher.SearchByStr("1.1.1.1")
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.ErrorIs(t, searcher.With(func(s *Searcher) error { return nil }), ErrClosed)
+	assert.Equal(t, IPv4, searcher.GetHeader().IPVersion)
+	searcher.Close()
+}
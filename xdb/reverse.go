@@ -0,0 +1,123 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// reverse lookup, find all the ip ranges of the regions matched by a filter.
+// the segments are walked with the SegmentIterator, so the original
+// source file is not required.
+
+package xdb
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// RegionFilter match the regions by fields or by the raw strings,
+// the empty conditions are ignored, and all the set ones should be matched.
+// the fields are matched after ParseRegion with the field layout of the xdb,
+// so the empty placeholder "0" could not be matched by the fields.
+type RegionFilter struct {
+	Country  string
+	Area     string
+	Province string
+	City     string
+	ISP      string
+
+	// the raw region head, tail or the full region string
+	Head   string
+	Tail   string
+	Region string
+}
+
+// IsEmpty check if no condition is set
+func (f *RegionFilter) IsEmpty() bool {
+	return *f == RegionFilter{}
+}
+
+// Match check if the segment is matched with the field layout of the xdb
+func (f *RegionFilter) Match(seg *Segment, fieldLayout []string) bool {
+	if f.Head != "" && f.Head != seg.RegionHead {
+		return false
+	}
+
+	if f.Tail != "" && f.Tail != seg.RegionTail {
+		return false
+	}
+
+	if f.Region != "" && f.Region != seg.Region() {
+		return false
+	}
+
+	if f.Country == "" && f.Area == "" && f.Province == "" && f.City == "" && f.ISP == "" {
+		return true
+	}
+
+	region := ParseRegion(seg.Region(), fieldLayout)
+	return matchField(f.Country, region.Country) &&
+		matchField(f.Area, region.Area) &&
+		matchField(f.Province, region.Province) &&
+		matchField(f.City, region.City) &&
+		matchField(f.ISP, region.ISP)
+}
+
+func matchField(cond string, field string) bool {
+	return cond == "" || cond == field
+}
+
+// SearchRangesByRegion find all the ip ranges with the regions matched by the filter,
+// in address order. an empty filter matches all the ranges.
+func (s *Searcher) SearchRangesByRegion(filter *RegionFilter) ([]*IPRange, error) {
+	var ranges []*IPRange
+
+	// the match results cached by the region
+	var matched = map[string]bool{}
+	var it = s.NewSegmentIterator()
+	for it.Next() {
+		seg := it.Segment()
+		region := seg.Region()
+		match, has := matched[region]
+		if !has {
+			match = filter.Match(seg, s.header.FieldLayout)
+			matched[region] = match
+		}
+
+		if match {
+			ranges = append(ranges, &IPRange{
+				StartIP:   seg.StartIP,
+				EndIP:     seg.EndIP,
+				StartAddr: seg.StartAddr,
+				EndAddr:   seg.EndAddr,
+				Region:    region,
+			})
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("iterate segments: %w", err)
+	}
+
+	return ranges, nil
+}
+
+// RangesToPrefixes merge the adjacent ranges in address order,
+// and return the minimal CIDR blocks covering all of them
+func RangesToPrefixes(ranges []*IPRange) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for i := 0; i < len(ranges); {
+		sip, eip := ranges[i].bounds()
+		for i++; i < len(ranges); i++ {
+			// the Next of the max ip is invalid and never matched
+			next, end := ranges[i].bounds()
+			if next != eip.Next() {
+				break
+			}
+			eip = end
+		}
+
+		prefixes = append(prefixes, AddrRangeToPrefixes(sip, eip)...)
+	}
+
+	return prefixes
+}
@@ -0,0 +1,93 @@
+package xdb
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchRangesByRegion(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	cases := []struct {
+		filter RegionFilter
+		expect []string
+	}{
+		{RegionFilter{Country: "法国"}, []string{
+			"2.12.134.0|2.12.139.255|法国|Ille-et-Vilaine|0|橘子电信",
+			"2.12.140.0|2.13.0.255|法国|0|0|橘子电信",
+		}},
+		{RegionFilter{Province: "Ille-et-Vilaine"}, []string{
+			"2.12.134.0|2.12.139.255|法国|Ille-et-Vilaine|0|橘子电信",
+		}},
+		{RegionFilter{ISP: "电信"}, []string{
+			"0.0.0.0|2.12.133.255|中国|广东省|深圳市|电信",
+		}},
+		{RegionFilter{Country: "法国", ISP: "电信"}, nil},
+		{RegionFilter{Head: "美国|0"}, []string{
+			"2.13.1.0|255.255.255.255|美国|0|0|0",
+		}},
+		{RegionFilter{Tail: "0|橘子电信"}, []string{
+			"2.12.134.0|2.12.139.255|法国|Ille-et-Vilaine|0|橘子电信",
+			"2.12.140.0|2.13.0.255|法国|0|0|橘子电信",
+		}},
+		{RegionFilter{Region: "法国|0|0|橘子电信"}, []string{
+			"2.12.140.0|2.13.0.255|法国|0|0|橘子电信",
+		}},
+	}
+
+	for _, c := range cases {
+		ranges, err := searcher.SearchRangesByRegion(&c.filter)
+		require.NoError(t, err)
+
+		var actual []string
+		for _, r := range ranges {
+			actual = append(actual, r.String())
+		}
+		assert.Equal(t, c.expect, actual, "filter %+v", c.filter)
+	}
+}
+
+func TestRangesToPrefixes(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	ranges, err := searcher.SearchRangesByRegion(&RegionFilter{Country: "法国"})
+	require.NoError(t, err)
+
+	// the adjacent ranges are merged before converted
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("2.12.134.0/23"),
+		netip.MustParsePrefix("2.12.136.0/21"),
+		netip.MustParsePrefix("2.12.144.0/20"),
+		netip.MustParsePrefix("2.12.160.0/19"),
+		netip.MustParsePrefix("2.12.192.0/18"),
+		netip.MustParsePrefix("2.13.0.0/24"),
+	}, RangesToPrefixes(ranges))
+}
+
+func TestSearchRangesByRegionV6(t *testing.T) {
+	for _, policy := range []IndexPolicy{VectorIndexPolicy, BTreeIndexPolicy} {
+		searcher, err := NewWithBuffer(buildTestDbWithPolicy(t, testSegmentsPartialV6, policy))
+		require.NoError(t, err)
+
+		ranges, err := searcher.SearchRangesByRegion(&RegionFilter{Country: "中国"})
+		require.NoError(t, err)
+		var actual []string
+		for _, r := range ranges {
+			actual = append(actual, r.String())
+		}
+		assert.Equal(t, []string{
+			"2001:250::|2001:250::ffff|中国|北京|北京市|教育网",
+			"2001:251::|2001:251::ffff|中国|北京|北京市|教育网",
+		}, actual, policy.String())
+
+		assert.Equal(t, []netip.Prefix{
+			netip.MustParsePrefix("2001:250::/112"),
+			netip.MustParsePrefix("2001:251::/112"),
+		}, RangesToPrefixes(ranges))
+	}
+}
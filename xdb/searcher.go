@@ -4,7 +4,8 @@
 
 // ---
 // ip2region database v2.0 searcher.
-// @Note this is a Not thread safe implementation.
+// @Note a Searcher is safe for concurrent use by multiple goroutines once
+// it is configured, all the lookups are done with positional reads.
 //
 // @Author Lion <chenxin619315@gmail.com>
 // @Date   2022/06/16
@@ -12,10 +13,15 @@
 package xdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -26,6 +32,18 @@ const (
 	VectorIndexSize      = 8
 	VectorIndexLength    = VectorIndexRows * VectorIndexCols * VectorIndexSize
 	RegionIndexBlockSize = 8
+
+	// the xdb using the region record extensions recorded in the header flags,
+	// older searchers reject it with ErrUnsupportedVersion instead of reading the records wrong
+	VersionExtended = 3
+
+	// the field layout is saved in header[64:128]
+	HeaderFieldLayoutOffset = 64
+	HeaderFieldLayoutLength = 64
+
+	// ipv6 segment index: 14 bytes start ip tail, 14 bytes end ip tail, 4 bytes region ptr
+	IPv6TailLength           = 14
+	IPv6RegionIndexBlockSize = IPv6TailLength*2 + 4
 )
 
 const (
@@ -35,6 +53,10 @@ const (
 	REGION_STR_SEP         = "|"
 	// 地域信息串中信息位长度 2B for headOffset, 1B for tail len
 	REGION_BLOCK_INFO_SIZE = 3
+	// 4B for headOffset, 1B for tail len, with the HeaderFlagWideHeadOffset flag
+	REGION_WIDE_BLOCK_INFO_SIZE = 5
+	// the max head or tail string length with the HeaderFlagVarintLength flag
+	REGION_MAX_LENGTH = 0xFFFF
 )
 
 type CachePolicy int
@@ -44,6 +66,9 @@ const (
 	CACHE_POLICY_FILE CachePolicy = iota
 	CACHE_POLICY_VECTOR
 	CACHE_POLICY_MEMORY
+	// read only mmap the whole xdb file,
+	// the page cache is shared by all the processes mapping the same file
+	CACHE_POLICY_MMAP
 )
 
 // --- Index policy define
@@ -63,6 +88,26 @@ func (i IndexPolicy) String() string {
 	}
 }
 
+// --- IP version define
+
+type IPVersion uint16
+
+const (
+	IPv4 IPVersion = 4
+	IPv6 IPVersion = 6
+)
+
+func (v IPVersion) String() string {
+	switch v {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	default:
+		return "unknown"
+	}
+}
+
 // --- Header define
 
 type Header struct {
@@ -73,41 +118,169 @@ type Header struct {
 	StartIndexPtr      uint32
 	EndIndexPtr        uint32
 	RegionHeadStartPtr uint32
+	IPVersion          IPVersion
+	Flags              uint16
+
+	// the layout of the xdb data, the igr one or the original ip2region one
+	Format Format
+
+	// the optional metadata section, zero for the xdb files made without it
+	MetadataPtr    uint32
+	MetadataLength uint32
+
+	// the checksums of the sections, valid with the HeaderFlagChecksum flag
+	Checksums Checksums
+
+	// field names of the region string, empty for the xdb files made without it
+	FieldLayout []string
 }
 
 func NewHeader(input []byte) (*Header, error) {
-	if len(input) < 16 {
-		return nil, fmt.Errorf("invalid input buffer")
+	if len(input) < 22 {
+		return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid header length %d", len(input))}
 	}
 
-	return &Header{
+	header := &Header{
 		Version:            binary.LittleEndian.Uint16(input),
 		IndexPolicy:        IndexPolicy(binary.LittleEndian.Uint16(input[2:])),
 		CreatedAt:          binary.LittleEndian.Uint32(input[4:]),
 		StartIndexPtr:      binary.LittleEndian.Uint32(input[8:]),
 		EndIndexPtr:        binary.LittleEndian.Uint32(input[12:]),
 		RegionHeadStartPtr: binary.LittleEndian.Uint32(input[16:]),
-	}, nil
+		IPVersion:          IPVersion(binary.LittleEndian.Uint16(input[20:])),
+	}
+
+	if header.Version != VersionNo && header.Version != VersionExtended {
+		return nil, fmt.Errorf("%w `%d`", ErrUnsupportedVersion, header.Version)
+	}
+
+	// the original ip2region xdb is ipv4 only, and nothing else is recorded in its header
+	if header.Version == VersionNo && isUpstreamHeader(input) {
+		header.Format = UpstreamFormat
+		header.IPVersion = IPv4
+		return header, nil
+	}
+
+	// xdb files made before the ipv6 support leave the ip version empty
+	switch header.IPVersion {
+	case 0:
+		header.IPVersion = IPv4
+	case IPv4, IPv6:
+	default:
+		return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid ip version `%d`", header.IPVersion)}
+	}
+
+	// flags, metadata section: 4 bytes ptr + 4 bytes length
+	if len(input) >= 32 {
+		header.Flags = binary.LittleEndian.Uint16(input[22:])
+		header.MetadataPtr = binary.LittleEndian.Uint32(input[24:])
+		header.MetadataLength = binary.LittleEndian.Uint32(input[28:])
+	}
+
+	// checksums of the header, vector index, region and segment index
+	if len(input) >= HeaderChecksumOffset+16 {
+		header.Checksums = Checksums{
+			Header:       binary.LittleEndian.Uint32(input[HeaderChecksumOffset:]),
+			VectorIndex:  binary.LittleEndian.Uint32(input[HeaderChecksumOffset+4:]),
+			Region:       binary.LittleEndian.Uint32(input[HeaderChecksumOffset+8:]),
+			SegmentIndex: binary.LittleEndian.Uint32(input[HeaderChecksumOffset+12:]),
+		}
+	}
+
+	// field layout: 1 byte length + layout string joined with REGION_STR_SEP
+	if len(input) > HeaderFieldLayoutOffset {
+		layoutLen := int(input[HeaderFieldLayoutOffset])
+		layoutPtr := HeaderFieldLayoutOffset + 1
+		if layoutLen > 0 {
+			if layoutPtr+layoutLen > len(input) {
+				return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid field layout length `%d`", layoutLen)}
+			}
+			header.FieldLayout = strings.Split(string(input[layoutPtr:layoutPtr+layoutLen]), REGION_STR_SEP)
+		}
+	}
+
+	return header, nil
+}
+
+// WideHeadOffset check if the region tail records save the region head offset in 4 bytes,
+// which is required by the region head block over 64 KiB
+func (h *Header) WideHeadOffset() bool {
+	return h.Flags&HeaderFlagWideHeadOffset != 0
+}
+
+// VarintLength check if the region head and tail strings are prefixed with the uvarint length,
+// which allows the heads of REGION_BASE_BLOCK_SIZE bytes or more and the tails over 255 bytes
+func (h *Header) VarintLength() bool {
+	return h.Flags&HeaderFlagVarintLength != 0
+}
+
+// regionLength parse the length prefix of the region head or tail string,
+// and return the string length and the byte size of the prefix
+func (h *Header) regionLength(buff []byte) (int, int, error) {
+	if !h.VarintLength() {
+		if len(buff) == 0 {
+			return 0, 0, fmt.Errorf("%w: missing length prefix", ErrCorruptDB)
+		}
+
+		return int(buff[0]), 1, nil
+	}
+
+	length, n := binary.Uvarint(buff)
+	if n <= 0 || length > REGION_MAX_LENGTH {
+		return 0, 0, fmt.Errorf("%w: invalid varint length prefix", ErrCorruptDB)
+	}
+
+	return int(length), n, nil
+}
+
+// regionTailInfoSize return the byte size of the head offset and length before the tail string
+func (h *Header) regionTailInfoSize() int {
+	if h.WideHeadOffset() {
+		return REGION_WIDE_BLOCK_INFO_SIZE
+	}
+
+	return REGION_BLOCK_INFO_SIZE
+}
+
+// regionHeadOffset return the region head offset at the start of the region tail record
+func (h *Header) regionHeadOffset(buff []byte) int64 {
+	if h.WideHeadOffset() {
+		return int64(binary.LittleEndian.Uint32(buff))
+	}
+
+	return int64(binary.LittleEndian.Uint16(buff))
 }
 
 // --- searcher implementation
 
 type Searcher struct {
-	handle *os.File
+	// the xdb file opened by the searcher, closed with Close
+	handle io.Closer
+
+	// positional reader of the xdb data and its size,
+	// the handle or any io.ReaderAt specified with NewWithReaderAt
+	reader io.ReaderAt
+	size   int64
 
 	// header info
-	header  *Header
-	ioCount int
+	header *Header
+
+	// io count of the last search, only kept for GetIOCount.
+	// use SearchWithIOCount to get the io count of a specified search.
+	ioCount atomic.Int64
 
 	// use it only when this feature enabled.
 	// Preload the vector index will reduce the number of IO operations
 	// thus speedup the search process
-	vectorIndex []byte
+	vectorIndex atomic.Pointer[[]byte]
 
 	// content buffer.
 	// running with the whole xdb file cached
 	contentBuff []byte
 
+	// the content buffer is mapped from the xdb file, and unmapped by Close
+	mmapped bool
+
 	// enable full search or just find length-limited tail string
 	searchMode bool
 
@@ -116,6 +289,7 @@ type Searcher struct {
 }
 
 // 设置地域尾部信息默认查询长度，默认64（Bytes）
+// 此选项需在并发查询开始前设置
 // 此选项若设置为最大值255，所有查询io将均为 num+1，此时SetSearchMode()不再影响查询结果
 // 此选项仅建议在优化原始文件`地域尾部信息`长度后进行调整
 func (s *Searcher) SetMatchTailLen(l uint8) *Searcher {
@@ -128,6 +302,7 @@ func (s *Searcher) GetMatchTailLen() uint8 {
 }
 
 // 设置是否开启完全查询，默认开启，此选项仅影响返回的地域尾部信息长度
+// 此选项需在并发查询开始前设置
 // true 开启时，将获取完整地域尾部信息，若原始文件有自定义扩充，可能导致查询io 为 num + 2
 // false 关闭时，将仅截取默认长度的地域尾部信息，默认长度由SetMatchTailLen()决定，此操作将固定查询io为 num+1
 func (s *Searcher) SetSearchMode(isFullSearch bool) *Searcher {
@@ -139,51 +314,86 @@ func (s *Searcher) GetSearchMode() bool {
 	return s.searchMode
 }
 
+// LoadVectorIndex preload the vector index, it is safe to call it
+// while other goroutines are searching
 func (s *Searcher) LoadVectorIndex() error {
 	// loaded already
-	if s.vectorIndex != nil {
+	if s.vectorIndex.Load() != nil {
 		return nil
 	}
 
 	// load all the vector index block
-	_, err := s.handle.Seek(HeaderInfoLength, 0)
-	if err != nil {
-		return fmt.Errorf("seek to vector index: %w", err)
-	}
-
+	var ioCount int
 	var buff = make([]byte, VectorIndexLength)
-	rLen, err := s.handle.Read(buff)
+	err := s.read(HeaderInfoLength, buff, &ioCount)
 	if err != nil {
-		return err
-	}
-
-	if rLen != len(buff) {
-		return fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+		return fmt.Errorf("read vector index: %w", err)
 	}
 
-	s.vectorIndex = buff
+	s.setVectorIndex(buff)
 	return nil
 }
 
 // ClearVectorIndex clear preloaded vector index cache
 func (s *Searcher) ClearVectorIndex() {
-	s.vectorIndex = nil
+	s.vectorIndex.Store(nil)
 }
 
+func (s *Searcher) setVectorIndex(vIndex []byte) {
+	if vIndex == nil {
+		s.vectorIndex.Store(nil)
+		return
+	}
+
+	s.vectorIndex.Store(&vIndex)
+}
+
+// Option is applied to the searcher created by Create or CreateFromFS, eg: WithVerify
+type Option func(s *Searcher) error
+
 // 创建查询器
 // @var dbPath string xdb文件路径
 // @var cachePolicy CachePolicy 缓存策略枚举值
-func Create(dbPath string, cachePolicy CachePolicy) (*Searcher, error) {
+// @var opts []Option 创建后应用的选项，如 WithVerify()
+func Create(dbPath string, cachePolicy CachePolicy, opts ...Option) (*Searcher, error) {
+	searcher, err := create(dbPath, cachePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return searcher.apply(opts)
+}
+
+// apply the options, the searcher is closed on failure
+func (s *Searcher) apply(opts []Option) (*Searcher, error) {
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func create(dbPath string, cachePolicy CachePolicy) (*Searcher, error) {
 	switch cachePolicy {
 	case CACHE_POLICY_FILE:
 		return NewWithFileOnly(dbPath)
 	case CACHE_POLICY_VECTOR:
-		vIndex, err := LoadVectorIndexFromFile(dbPath)
+		// the header is validated before the vector index is loaded
+		searcher, err := NewWithFileOnly(dbPath)
 		if err != nil {
+			return nil, err
+		}
+
+		err = searcher.LoadVectorIndex()
+		if err != nil {
+			searcher.Close()
 			return nil, fmt.Errorf("failed to load vector index from `%s`: %w", dbPath, err)
 		}
 
-		return NewWithVectorIndex(dbPath, vIndex)
+		return searcher, nil
 	case CACHE_POLICY_MEMORY:
 		cBuff, err := LoadContentFromFile(dbPath)
 		if err != nil {
@@ -191,24 +401,72 @@ func Create(dbPath string, cachePolicy CachePolicy) (*Searcher, error) {
 		}
 
 		return NewWithBuffer(cBuff)
+	case CACHE_POLICY_MMAP:
+		return NewWithMmap(dbPath)
 	default:
-		return nil, fmt.Errorf("invalid cache policy `%d`", cachePolicy)
+		return nil, fmt.Errorf("%w `%d`", ErrInvalidCachePolicy, cachePolicy)
 	}
 }
 
-func baseNew(dbFile string, vIndex []byte, cBuff []byte) (*Searcher, error) {
-	var err error
+// createWithFile create a searcher with the opened xdb file of the specified size,
+// the handle is kept by the file and vector policies, and it is closed otherwise or on failure.
+func createWithFile(handle *os.File, size int64, cachePolicy CachePolicy) (*Searcher, error) {
+	switch cachePolicy {
+	case CACHE_POLICY_FILE, CACHE_POLICY_VECTOR:
+		searcher, err := readerAtNew(handle, size, nil)
+		if err != nil {
+			_ = handle.Close()
+			return nil, err
+		}
+
+		searcher.handle = handle
+		if cachePolicy == CACHE_POLICY_VECTOR {
+			err = searcher.LoadVectorIndex()
+			if err != nil {
+				searcher.Close()
+				return nil, fmt.Errorf("failed to load vector index from `%s`: %w", handle.Name(), err)
+			}
+		}
+
+		return searcher, nil
+	case CACHE_POLICY_MEMORY:
+		defer func() {
+			_ = handle.Close()
+		}()
+
+		cBuff, err := LoadContentFromReaderAt(handle, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load content from '%s': %w", handle.Name(), err)
+		}
+
+		return NewWithBuffer(cBuff)
+	case CACHE_POLICY_MMAP:
+		defer func() {
+			_ = handle.Close()
+		}()
 
+		return newWithMmapFile(handle, size)
+	default:
+		_ = handle.Close()
+		return nil, fmt.Errorf("%w `%d`", ErrInvalidCachePolicy, cachePolicy)
+	}
+}
+
+func baseNew(dbFile string, vIndex []byte, cBuff []byte) (*Searcher, error) {
 	// content buff first
 	if cBuff != nil {
 		header, err := LoadHeaderFromBuff(cBuff)
 		if err != nil {
 			return nil, err
 		}
+		err = header.Validate(int64(len(cBuff)))
+		if err != nil {
+			return nil, err
+		}
 		return &Searcher{
-			vectorIndex:  nil,
 			header:       header,
 			contentBuff:  cBuff,
+			size:         int64(len(cBuff)),
 			searchMode:   true,
 			matchTailLen: REGION_BASE_BLOCK_SIZE,
 		}, nil
@@ -220,18 +478,42 @@ func baseNew(dbFile string, vIndex []byte, cBuff []byte) (*Searcher, error) {
 		return nil, err
 	}
 
-	header, err := LoadHeader(handle)
+	fi, err := handle.Stat()
+	if err != nil {
+		_ = handle.Close()
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	searcher, err := readerAtNew(handle, fi.Size(), vIndex)
 	if err != nil {
+		_ = handle.Close()
 		return nil, err
 	}
 
-	return &Searcher{
-		handle:       handle,
+	searcher.handle = handle
+	return searcher, nil
+}
+
+func readerAtNew(reader io.ReaderAt, size int64, vIndex []byte) (*Searcher, error) {
+	header, err := LoadHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = header.Validate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	searcher := &Searcher{
 		header:       header,
-		vectorIndex:  vIndex,
+		reader:       reader,
+		size:         size,
 		searchMode:   true,
 		matchTailLen: REGION_BASE_BLOCK_SIZE,
-	}, nil
+	}
+	searcher.setVectorIndex(vIndex)
+	return searcher, nil
 }
 
 func NewWithFileOnly(dbFile string) (*Searcher, error) {
@@ -246,71 +528,382 @@ func NewWithBuffer(cBuff []byte) (*Searcher, error) {
 	return baseNew("", nil, cBuff)
 }
 
+// NewWithReaderAt create a searcher reading the xdb data of the specified size from any io.ReaderAt,
+// eg: an embedded byte slice, a file inside an archive or a custom cache layer.
+// all the reads are positional, and the reader is not closed by Close.
+// call LoadVectorIndex to preload the vector index if needed.
+func NewWithReaderAt(reader io.ReaderAt, size int64) (*Searcher, error) {
+	return readerAtNew(reader, size, nil)
+}
+
+// NewWithMmap create a searcher with the xdb file mapped into memory read only,
+// it searches the same way as the content buffer does
+func NewWithMmap(dbFile string) (*Searcher, error) {
+	handle, err := os.OpenFile(dbFile, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mapping keeps valid after the file closed
+	defer func() {
+		_ = handle.Close()
+	}()
+
+	fi, err := handle.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	return newWithMmapFile(handle, fi.Size())
+}
+
+// newWithMmapFile map the opened xdb file of the specified size, the handle is not closed
+func newWithMmapFile(handle *os.File, size int64) (*Searcher, error) {
+	if size < HeaderInfoLength {
+		return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid xdb file size %d", size)}
+	}
+
+	cBuff, err := mmap(handle, size)
+	if err != nil {
+		return nil, fmt.Errorf("mmap xdb file `%s`: %w", handle.Name(), err)
+	}
+
+	searcher, err := baseNew("", nil, cBuff)
+	if err != nil {
+		_ = munmap(cBuff)
+		return nil, err
+	}
+
+	searcher.mmapped = true
+	return searcher, nil
+}
+
+// CreateFromFS create a searcher with the xdb file in the fs.FS, eg: an embed.FS
+// CACHE_POLICY_FILE and CACHE_POLICY_VECTOR require the opened file implements io.ReaderAt,
+// which is true for the embed.FS and os.DirFS, and CACHE_POLICY_MMAP is not supported.
+func CreateFromFS(fsys fs.FS, name string, cachePolicy CachePolicy, opts ...Option) (*Searcher, error) {
+	searcher, err := createFromFS(fsys, name, cachePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return searcher.apply(opts)
+}
+
+func createFromFS(fsys fs.FS, name string, cachePolicy CachePolicy) (*Searcher, error) {
+	switch cachePolicy {
+	case CACHE_POLICY_FILE, CACHE_POLICY_VECTOR:
+		file, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		searcher, err := newWithFSFile(file, cachePolicy)
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("create searcher with `%s`: %w", name, err)
+		}
+		return searcher, nil
+	case CACHE_POLICY_MEMORY:
+		cBuff, err := LoadContentFromFS(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load content from '%s': %w", name, err)
+		}
+
+		return NewWithBuffer(cBuff)
+	default:
+		return nil, fmt.Errorf("%w `%d` for fs", ErrInvalidCachePolicy, cachePolicy)
+	}
+}
+
+func newWithFSFile(file fs.File, cachePolicy CachePolicy) (*Searcher, error) {
+	reader, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("file does not implement io.ReaderAt, try CACHE_POLICY_MEMORY instead")
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	searcher, err := readerAtNew(reader, fi.Size(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachePolicy == CACHE_POLICY_VECTOR {
+		err = searcher.LoadVectorIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	searcher.handle = file
+	return searcher, nil
+}
+
+// Close release the file handle or the mapped memory,
+// no more search should be running or started on the searcher
 func (s *Searcher) Close() {
 	if s.handle != nil {
 		err := s.handle.Close()
 		if err != nil {
 			return
 		}
+		s.handle = nil
+	}
+
+	if s.mmapped {
+		s.mmapped = false
+		_ = munmap(s.contentBuff)
+		s.contentBuff = nil
 	}
 }
 
-// GetIOCount return the global io count for the last search
+// GetIOCount return the global io count for the last search.
+// Under concurrent searching the value may belong to any of the latest searches,
+// use SearchWithIOCount or SearchByStrWithIOCount to get the io count of a specified search.
 func (s *Searcher) GetIOCount() int {
-	return s.ioCount
+	return int(s.ioCount.Load())
 }
 
 // SearchByStr find the region for the specified ip string
+// the ip string should be an ipv4 or ipv6 address according to the ip version of the xdb
 func (s *Searcher) SearchByStr(str string) (string, error) {
+	region, _, err := s.SearchByStrWithIOCount(str)
+	return region, err
+}
+
+// SearchByStrWithIOCount find the region and the io count for the specified ip string
+func (s *Searcher) SearchByStrWithIOCount(str string) (string, int, error) {
+	if s.header.IPVersion == IPv6 {
+		ip, err := CheckIPv6(str)
+		if err != nil {
+			return "", 0, err
+		}
+
+		return s.SearchV6WithIOCount(ip)
+	}
+
 	ip, err := CheckIP(str)
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+
+	return s.SearchWithIOCount(ip)
+}
+
+// SearchRegionByStr find the structured region for the specified ip string
+func (s *Searcher) SearchRegionByStr(str string) (*Region, error) {
+	region, err := s.SearchByStr(str)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.Search(ip)
+	return ParseRegion(region, s.header.FieldLayout), nil
+}
+
+// SearchRegion find the structured region for the specified long ip
+func (s *Searcher) SearchRegion(ip uint32) (*Region, error) {
+	region, err := s.Search(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRegion(region, s.header.FieldLayout), nil
+}
+
+// SearchRegionV6 find the structured region for the specified 16 bytes ipv6 address
+func (s *Searcher) SearchRegionV6(ip [16]byte) (*Region, error) {
+	region, err := s.SearchV6(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRegion(region, s.header.FieldLayout), nil
+}
+
+// GetHeader return the header info of the xdb
+func (s *Searcher) GetHeader() *Header {
+	return s.header
 }
 
 // Search find the region for the specified long ip
 func (s *Searcher) Search(ip uint32) (string, error) {
-	// reset the global ioCount
-	s.ioCount = 0
+	region, _, err := s.SearchWithIOCount(ip)
+	return region, err
+}
 
-	// locate the segment index block based on the vector index
-	var il0 = (ip >> 24) & 0xFF
-	var il1 = (ip >> 16) & 0xFF
-	var idx = il0*VectorIndexCols*VectorIndexSize + il1*VectorIndexSize
-	var ipTail = uint16(ip & IP_TAIL_PATTERN)
-	var sPtr, ePtr = uint32(0), uint32(0)
-	if s.vectorIndex != nil {
-		sPtr = binary.LittleEndian.Uint32(s.vectorIndex[idx:])
-		ePtr = binary.LittleEndian.Uint32(s.vectorIndex[idx+4:])
-	} else if s.contentBuff != nil {
-		sPtr = binary.LittleEndian.Uint32(s.contentBuff[HeaderInfoLength+idx:])
-		ePtr = binary.LittleEndian.Uint32(s.contentBuff[HeaderInfoLength+idx+4:])
-	} else {
-		// read the vector index block
-		var buff = make([]byte, VectorIndexSize)
-		err := s.read(int64(HeaderInfoLength+idx), buff)
+// SearchWithIOCount find the region and the io count for the specified long ip
+func (s *Searcher) SearchWithIOCount(ip uint32) (string, int, error) {
+	var ioCount int
+	region, err := s.search(ip, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	return region, ioCount, err
+}
+
+// SearchV6 find the region for the specified 16 bytes ipv6 address
+func (s *Searcher) SearchV6(ip [16]byte) (string, error) {
+	region, _, err := s.SearchV6WithIOCount(ip)
+	return region, err
+}
+
+// SearchV6WithIOCount find the region and the io count for the specified 16 bytes ipv6 address
+func (s *Searcher) SearchV6WithIOCount(ip [16]byte) (string, int, error) {
+	var ioCount int
+	region, err := s.searchV6(ip, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	return region, ioCount, err
+}
+
+// AppendSearch append the region of the specified long ip to dst and return the extended buffer,
+// it does not allocate once dst has enough capacity, so it fits the hot paths of high qps services.
+// dst is returned unchanged on error.
+func (s *Searcher) AppendSearch(dst []byte, ip uint32) ([]byte, error) {
+	var ioCount int
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	region, err := s.appendSearch(dst, ip, lk, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	if err != nil {
+		return dst, err
+	}
+
+	return region, nil
+}
+
+// AppendSearchV6 is the AppendSearch of the 16 bytes ipv6 address
+func (s *Searcher) AppendSearchV6(dst []byte, ip [16]byte) ([]byte, error) {
+	var ioCount int
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	region, err := s.appendSearchV6(dst, ip, lk, &ioCount)
+	s.ioCount.Store(int64(ioCount))
+	if err != nil {
+		return dst, err
+	}
+
+	return region, nil
+}
+
+// AppendSearchByStr is the AppendSearch of the ip string,
+// the ip string should be an ipv4 or ipv6 address according to the ip version of the xdb
+func (s *Searcher) AppendSearchByStr(dst []byte, str string) ([]byte, error) {
+	if s.header.IPVersion == IPv6 {
+		ip, err := CheckIPv6(str)
 		if err != nil {
-			return "", fmt.Errorf("read vector index block at %d: %w", HeaderInfoLength+idx, err)
+			return dst, err
 		}
 
-		sPtr = binary.LittleEndian.Uint32(buff)
-		ePtr = binary.LittleEndian.Uint32(buff[4:])
+		return s.AppendSearchV6(dst, ip)
+	}
+
+	ip, err := CheckIP(str)
+	if err != nil {
+		return dst, err
+	}
+
+	return s.AppendSearch(dst, ip)
+}
+
+// lookup holds the scratch buffers of a search, it is reused with the lookupPool,
+// so the reader based searches do not allocate the buffers every time,
+// and the content buffer based searches slice the content buffer directly.
+type lookup struct {
+	vector [VectorIndexSize]byte
+	index  [IPv6BTreeRegionIndexBlockSize]byte
+	head   [REGION_BASE_BLOCK_SIZE]byte
+	// the region tail block, up to 255 bytes tail string with its info bytes,
+	// the longer tails with the varint length are read into a new buffer
+	tail []byte
+	// the region string buffer of the string results
+	result []byte
+}
+
+var lookupPool = sync.Pool{
+	New: func() interface{} {
+		return &lookup{
+			tail:   make([]byte, 0xFF+REGION_WIDE_BLOCK_INFO_SIZE),
+			result: make([]byte, 0, 0xFF+REGION_BASE_BLOCK_SIZE),
+		}
+	},
+}
+
+func getLookup() *lookup {
+	return lookupPool.Get().(*lookup)
+}
+
+func putLookup(l *lookup) {
+	lookupPool.Put(l)
+}
+
+func (s *Searcher) search(ip uint32, ioCount *int) (string, error) {
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	var err error
+	lk.result, err = s.appendSearch(lk.result[:0], ip, lk, ioCount)
+	if err != nil {
+		return "", err
+	}
+
+	return string(lk.result), nil
+}
+
+func (s *Searcher) appendSearch(dst []byte, ip uint32, lk *lookup, ioCount *int) ([]byte, error) {
+	regionPtr, _, _, err := s.locate(ip, lk, ioCount)
+	if err != nil {
+		return dst, err
+	}
+
+	return s.appendRegion(dst, regionPtr, lk, ioCount)
+}
+
+// locate find the segment index of the ip,
+// return its region ptr and the start, end ip of the segment
+func (s *Searcher) locate(ip uint32, lk *lookup, ioCount *int) (regionPtr int64, startIP uint32, endIP uint32, err error) {
+	if s.header.IPVersion != IPv4 {
+		return 0, 0, 0, fmt.Errorf("%w: ipv4 search on a %s xdb", ErrIPVersionMismatch, s.header.IPVersion)
+	}
+
+	if s.header.Format == UpstreamFormat {
+		return s.locateUpstream(ip, lk, ioCount)
+	}
+
+	if s.header.IndexPolicy == BTreeIndexPolicy {
+		return s.locateBTree(ip, lk, ioCount)
+	}
+
+	// locate the segment index block based on the vector index
+	var ipTail = uint16(ip & IP_TAIL_PATTERN)
+	sPtr, ePtr, err := s.vectorBlock((ip>>24)&0xFF, (ip>>16)&0xFF, lk, ioCount)
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
 	// fmt.Printf("sPtr=%d, ePtr=%d", sPtr, ePtr)
+	if sPtr == 0 && ePtr == 0 {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrNotFound, Long2IP(ip))
+	}
 
 	// binary search the segment index to get the region
-	var regionPtr int64
-	var buff = make([]byte, RegionIndexBlockSize)
-	var l, h = 0, int((ePtr - sPtr) / RegionIndexBlockSize)
+	var ipHead = ip &^ IP_TAIL_PATTERN
+	var count = indexBlockCount(sPtr, ePtr, RegionIndexBlockSize)
+	var l, h = 0, count
 	for l <= h {
 		m := (l + h) >> 1
+		// the ip is beyond the last block of the slot
+		if m == count {
+			break
+		}
+
 		p := sPtr + uint32(m*RegionIndexBlockSize)
-		err := s.read(int64(p), buff)
+		buff, err := s.view(int64(p), RegionIndexBlockSize, lk.index[:], ioCount)
 		if err != nil {
-			return "", fmt.Errorf("read segment index at %d: %w", p, err)
+			return 0, 0, 0, fmt.Errorf("read segment index at %d: %w", p, err)
 		}
 
 		// decode the data step by step to reduce the unnecessary operations
@@ -324,79 +917,311 @@ func (s *Searcher) Search(ip uint32) (string, error) {
 			} else {
 				// regionHeadOffset = int64(binary.LittleEndian.Uint16(buff[4:]))
 				regionPtr = int64(binary.LittleEndian.Uint32(buff[4:]))
+				startIP, endIP = ipHead|uint32(sip), ipHead|uint32(eip)
 				break
 			}
 		}
 	}
 
-	var regionBuff = make([]byte, s.matchTailLen+REGION_BLOCK_INFO_SIZE)
-	err := s.read(regionPtr, regionBuff)
+	if regionPtr == 0 {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrNotFound, Long2IP(ip))
+	}
+
+	return regionPtr, startIP, endIP, nil
+}
+
+func (s *Searcher) searchV6(ip [16]byte, ioCount *int) (string, error) {
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	var err error
+	lk.result, err = s.appendSearchV6(lk.result[:0], ip, lk, ioCount)
 	if err != nil {
-		return "", fmt.Errorf("read region tail data at %d: %w", regionPtr, err)
+		return "", err
 	}
 
-	regionHeadOffset := int64(binary.LittleEndian.Uint16(regionBuff[:2]))
-	// get region head string
-	var regionHeadBuff = make([]byte, REGION_BASE_BLOCK_SIZE)
-	err = s.read(int64(s.header.RegionHeadStartPtr)+regionHeadOffset, regionHeadBuff)
+	return string(lk.result), nil
+}
+
+func (s *Searcher) appendSearchV6(dst []byte, ip [16]byte, lk *lookup, ioCount *int) ([]byte, error) {
+	regionPtr, _, _, err := s.locateV6(ip, lk, ioCount)
 	if err != nil {
-		return "", fmt.Errorf("read region head data at %d: %w", int64(s.header.RegionHeadStartPtr)+regionHeadOffset, err)
+		return dst, err
+	}
+
+	return s.appendRegion(dst, regionPtr, lk, ioCount)
+}
+
+// locateV6 search the segment index for the 16 bytes ipv6 address,
+// return its region ptr and the start, end ip of the segment
+func (s *Searcher) locateV6(ip [16]byte, lk *lookup, ioCount *int) (regionPtr int64, startIP [16]byte, endIP [16]byte, err error) {
+	if s.header.IPVersion != IPv6 {
+		return 0, startIP, endIP, fmt.Errorf("%w: ipv6 search on a %s xdb", ErrIPVersionMismatch, s.header.IPVersion)
 	}
-	// return string(regionHeadBuff[18:]), nil
 
-	regionHeadBuff, _ = ParseDynamicBytes(regionHeadBuff)
+	if s.header.IndexPolicy == BTreeIndexPolicy {
+		return s.locateBTreeV6(ip, lk, ioCount)
+	}
+
+	// locate the segment index block based on the vector index
+	var ipTail = ip[2:]
+	sPtr, ePtr, err := s.vectorBlock(uint32(ip[0]), uint32(ip[1]), lk, ioCount)
+	if err != nil {
+		return 0, startIP, endIP, err
+	}
 
-	// first byte is lenth of the string behind
-	regionTailBuff, missingLen := ParseDynamicBytes(regionBuff[2:])
-	if s.searchMode && missingLen > 0 {
-		var missingTailBuff = make([]byte, missingLen)
-		err = s.read(regionPtr+int64(s.matchTailLen)+REGION_BLOCK_INFO_SIZE, missingTailBuff)
+	if sPtr == 0 && ePtr == 0 {
+		return 0, startIP, endIP, fmt.Errorf("%w: %s", ErrNotFound, IPv6ToString(ip))
+	}
+
+	// binary search the segment index to get the region
+	var count = indexBlockCount(sPtr, ePtr, IPv6RegionIndexBlockSize)
+	var l, h = 0, count
+	for l <= h {
+		m := (l + h) >> 1
+		// the ip is beyond the last block of the slot
+		if m == count {
+			break
+		}
+
+		p := sPtr + uint32(m*IPv6RegionIndexBlockSize)
+		buff, err := s.view(int64(p), IPv6RegionIndexBlockSize, lk.index[:], ioCount)
 		if err != nil {
-			return "", fmt.Errorf("read region tail missing data at %d: %w", regionPtr+int64(s.matchTailLen)+REGION_BLOCK_INFO_SIZE, err)
+			return 0, startIP, endIP, fmt.Errorf("read segment index at %d: %w", p, err)
 		}
-		regionTailBuff = append(regionTailBuff, missingTailBuff...)
+
+		// the ip tails are stored in big endian, so they could be compared as bytes
+		if bytes.Compare(ipTail, buff[:IPv6TailLength]) < 0 {
+			h = m - 1
+		} else if bytes.Compare(ipTail, buff[IPv6TailLength:IPv6TailLength*2]) > 0 {
+			l = m + 1
+		} else {
+			regionPtr = int64(binary.LittleEndian.Uint32(buff[IPv6TailLength*2:]))
+			startIP[0], startIP[1] = ip[0], ip[1]
+			endIP[0], endIP[1] = ip[0], ip[1]
+			copy(startIP[2:], buff[:IPv6TailLength])
+			copy(endIP[2:], buff[IPv6TailLength:IPv6TailLength*2])
+			break
+		}
+	}
+
+	if regionPtr == 0 {
+		return 0, startIP, endIP, fmt.Errorf("%w: %s", ErrNotFound, IPv6ToString(ip))
+	}
+
+	return regionPtr, startIP, endIP, nil
+}
+
+// vectorBlock return the segment index block range of the vector index
+// located by the first two bytes of the ip
+func (s *Searcher) vectorBlock(il0, il1 uint32, lk *lookup, ioCount *int) (sPtr uint32, ePtr uint32, err error) {
+	var idx = il0*VectorIndexCols*VectorIndexSize + il1*VectorIndexSize
+	if vectorIndex := s.vectorIndex.Load(); vectorIndex != nil {
+		if int(idx)+VectorIndexSize > len(*vectorIndex) {
+			return 0, 0, fmt.Errorf("%w: vector index block %d out of the loaded %d bytes", ErrCorruptDB, idx, len(*vectorIndex))
+		}
+
+		sPtr = binary.LittleEndian.Uint32((*vectorIndex)[idx:])
+		ePtr = binary.LittleEndian.Uint32((*vectorIndex)[idx+4:])
+	} else {
+		// slice or read the vector index block
+		buff, err := s.view(int64(HeaderInfoLength+idx), VectorIndexSize, lk.vector[:], ioCount)
+		if err != nil {
+			return 0, 0, fmt.Errorf("read vector index block at %d: %w", HeaderInfoLength+idx, err)
+		}
+
+		sPtr = binary.LittleEndian.Uint32(buff)
+		ePtr = binary.LittleEndian.Uint32(buff[4:])
 	}
 
-	return strings.Join([]string{
-		string(regionHeadBuff),
-		string(regionTailBuff),
-	}, REGION_STR_SEP), nil
+	return sPtr, ePtr, nil
+}
 
-	//fmt.Printf("dataLen: %d, dataPtr: %d", dataLen, dataPtr)
+// appendRegion append the region string at regionPtr to dst
+func (s *Searcher) appendRegion(dst []byte, regionPtr int64, lk *lookup, ioCount *int) ([]byte, error) {
+	if s.header.Format == UpstreamFormat {
+		return s.appendUpstreamRegion(dst, regionPtr, lk, ioCount)
+	}
 
-	// load and return the region data
-	// var regionBuff = make([]byte, dataLen)
-	// err := s.read(int64(dataPtr), regionBuff)
-	// if err != nil {
-	// 	return "", fmt.Errorf("read region at %d: %w", dataPtr, err)
-	// }
+	regionHeadOffset, regionTailBuff, err := s.regionTail(regionPtr, s.searchMode, lk, ioCount)
+	if err != nil {
+		return dst, err
+	}
+
+	regionHeadBuff, err := s.regionHead(regionHeadOffset, lk, ioCount)
+	if err != nil {
+		return dst, err
+	}
 
-	// return string(regionBuff), nil
+	dst = append(dst, regionHeadBuff...)
+	dst = append(dst, REGION_STR_SEP...)
+	dst = append(dst, regionTailBuff...)
+	return dst, nil
+}
+
+// indexBlockCount return the count of the segment index blocks of a vector slot,
+// the ePtr is the end of the last block, and the reserved slots share one block with the same sPtr and ePtr.
+func indexBlockCount(sPtr uint32, ePtr uint32, blockSize uint32) int {
+	if ePtr <= sPtr {
+		return 1
+	}
+
+	return int((ePtr - sPtr) / blockSize)
+}
+
+// readRegionTail load the region tail at regionPtr,
+// return the offset of its region head and a copy of the tail string bytes.
+// the tail is limited to the matchTailLen if not fullSearch.
+func (s *Searcher) readRegionTail(regionPtr int64, fullSearch bool, ioCount *int) (int64, []byte, error) {
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	regionHeadOffset, regionTailBuff, err := s.regionTail(regionPtr, fullSearch, lk, ioCount)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return regionHeadOffset, append([]byte(nil), regionTailBuff...), nil
+}
+
+// regionTail is the same as readRegionTail, but the tail string bytes returned
+// are sliced from the content buffer or the scratch buffer without copy
+func (s *Searcher) regionTail(regionPtr int64, fullSearch bool, lk *lookup, ioCount *int) (int64, []byte, error) {
+	var infoSize = s.header.regionTailInfoSize()
+	var loadLen = int(s.matchTailLen) + infoSize
+	if s.header.VarintLength() && loadLen < infoSize-1+binary.MaxVarintLen16 {
+		// the whole length prefix is required in the first read
+		loadLen = infoSize - 1 + binary.MaxVarintLen16
+	}
+	// the tail record could be right at the end of the data, eg: the btree xdb with only a few segments
+	if remain := s.size - regionPtr; remain < int64(loadLen) && remain > int64(infoSize) {
+		loadLen = int(remain)
+	}
+	regionBuff, err := s.view(regionPtr, loadLen, lk.tail, ioCount)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read region tail data at %d: %w", regionPtr, err)
+	}
+
+	regionHeadOffset := s.header.regionHeadOffset(regionBuff)
+
+	// the length prefix of the string behind the head offset
+	tailLen, prefixSize, err := s.header.regionLength(regionBuff[infoSize-1:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("region tail at %d: %w", regionPtr, err)
+	}
+
+	var start = infoSize - 1 + prefixSize
+	if start+tailLen <= loadLen {
+		return regionHeadOffset, regionBuff[start : start+tailLen], nil
+	}
+
+	// the tail is limited to the loaded part
+	if !fullSearch {
+		return regionHeadOffset, regionBuff[start:], nil
+	}
+
+	if s.contentBuff != nil {
+		regionBuff, err = s.view(regionPtr+int64(start), tailLen, nil, ioCount)
+		if err != nil {
+			return 0, nil, fmt.Errorf("read region tail missing data at %d: %w", regionPtr+int64(loadLen), err)
+		}
+
+		return regionHeadOffset, regionBuff, nil
+	}
+
+	// load the missing part right behind the loaded one,
+	// the tails longer than the scratch buffer are only allowed with the varint length
+	var buff = lk.tail
+	if start+tailLen > len(buff) {
+		buff = make([]byte, start+tailLen)
+		copy(buff, regionBuff)
+	}
+	err = s.read(regionPtr+int64(loadLen), buff[loadLen:start+tailLen], ioCount)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read region tail missing data at %d: %w", regionPtr+int64(loadLen), err)
+	}
+
+	return regionHeadOffset, buff[start : start+tailLen], nil
+}
+
+// readRegionHead load a copy of the region head string bytes at the specified offset of the region head block
+func (s *Searcher) readRegionHead(regionHeadOffset int64, ioCount *int) ([]byte, error) {
+	var lk = getLookup()
+	defer putLookup(lk)
+
+	regionHeadBuff, err := s.regionHead(regionHeadOffset, lk, ioCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), regionHeadBuff...), nil
+}
+
+// regionHead is the same as readRegionHead, but the head string bytes returned
+// are sliced from the content buffer or the scratch buffer without copy
+func (s *Searcher) regionHead(regionHeadOffset int64, lk *lookup, ioCount *int) ([]byte, error) {
+	var offset = int64(s.header.RegionHeadStartPtr) + regionHeadOffset
+	regionHeadBuff, err := s.view(offset, REGION_BASE_BLOCK_SIZE, lk.head[:], ioCount)
+	if err != nil {
+		return nil, fmt.Errorf("read region head data at %d: %w", offset, err)
+	}
+
+	headLen, prefixSize, err := s.header.regionLength(regionHeadBuff)
+	if err != nil {
+		return nil, fmt.Errorf("region head at %d: %w", offset, err)
+	}
+
+	if prefixSize+headLen <= len(regionHeadBuff) {
+		return regionHeadBuff[prefixSize : prefixSize+headLen], nil
+	}
+
+	// the long head with the varint length costs one more read
+	regionHeadBuff, err = s.view(offset+int64(prefixSize), headLen, make([]byte, headLen), ioCount)
+	if err != nil {
+		return nil, fmt.Errorf("read region head data at %d: %w", offset, err)
+	}
+
+	return regionHeadBuff, nil
+}
+
+// view return the n bytes at the offset, sliced from the content buffer without copy,
+// or read into the buff which should be at least n bytes.
+func (s *Searcher) view(offset int64, n int, buff []byte, ioCount *int) ([]byte, error) {
+	if s.contentBuff != nil {
+		if offset < 0 || offset+int64(n) > int64(len(s.contentBuff)) {
+			return nil, fmt.Errorf("%w: incomplete read, readed bytes should be %d", ErrCorruptDB, n)
+		}
+
+		return s.contentBuff[offset : offset+int64(n)], nil
+	}
+
+	buff = buff[:n]
+	return buff, s.read(offset, buff, ioCount)
 }
 
 // do the data read operation based on the setting.
 // content buffer first or will read from the file.
-// this operation will invoke the Seek for file based read.
-func (s *Searcher) read(offset int64, buff []byte) error {
+// reader based read use the positional ReadAt, so no seek state is shared
+// between the concurrent searches, and the io count is added to ioCount.
+func (s *Searcher) read(offset int64, buff []byte, ioCount *int) error {
 	if s.contentBuff != nil {
+		if offset < 0 || offset > int64(len(s.contentBuff)) {
+			return fmt.Errorf("%w: read offset %d out of range", ErrCorruptDB, offset)
+		}
+
 		cLen := copy(buff, s.contentBuff[offset:])
 		if cLen != len(buff) {
-			return fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+			return fmt.Errorf("%w: incomplete read, readed bytes should be %d", ErrCorruptDB, len(buff))
 		}
 	} else {
-		_, err := s.handle.Seek(offset, 0)
-		if err != nil {
-			return fmt.Errorf("seek to %d: %w", offset, err)
+		if offset < 0 || offset+int64(len(buff)) > s.size {
+			return fmt.Errorf("%w: incomplete read, readed bytes should be %d", ErrCorruptDB, len(buff))
 		}
 
-		s.ioCount++
-		rLen, err := s.handle.Read(buff)
+		*ioCount++
+		err := readFullAt(s.reader, buff, offset)
 		if err != nil {
-			return fmt.Errorf("handle read: %w", err)
-		}
-
-		if rLen != len(buff) {
-			return fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+			return fmt.Errorf("reader read: %w", err)
 		}
 	}
 
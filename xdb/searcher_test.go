@@ -1,9 +1,17 @@
 package xdb
 
 import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const defaultDbPathForTestOnly = "../data/igr.xdb"
@@ -28,3 +36,296 @@ func TestMemorySearcher(t *testing.T) {
 	region, _ := memorySe.SearchByStr("2.12.133.0")
 	assert.Equal(t, "法国|0|Ille-et-Vilaine|0|橘子电信", region, "set error")
 }
+
+var testCachePolicies = map[string]CachePolicy{
+	"file":   CACHE_POLICY_FILE,
+	"vector": CACHE_POLICY_VECTOR,
+	"memory": CACHE_POLICY_MEMORY,
+	"mmap":   CACHE_POLICY_MMAP,
+}
+
+func TestSearchWithIOCount(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	expectIO := map[CachePolicy]int{
+		CACHE_POLICY_FILE:   4,
+		CACHE_POLICY_VECTOR: 3,
+		CACHE_POLICY_MEMORY: 0,
+		CACHE_POLICY_MMAP:   0,
+	}
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			region, ioCount, err := searcher.SearchByStrWithIOCount("2.12.139.255")
+			require.NoError(t, err)
+			assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+			assert.Equal(t, expectIO[policy], ioCount)
+			assert.Equal(t, ioCount, searcher.GetIOCount())
+		})
+	}
+}
+
+func TestConcurrentSearch(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	cases := map[string]string{
+		"0.0.0.0":         "中国|广东省|深圳市|电信",
+		"2.12.133.255":    "中国|广东省|深圳市|电信",
+		"2.12.134.0":      "法国|Ille-et-Vilaine|0|橘子电信",
+		"2.12.140.0":      "法国|0|0|橘子电信",
+		"2.13.0.255":      "法国|0|0|橘子电信",
+		"2.13.1.0":        "美国|0|0|0",
+		"255.255.255.255": "美国|0|0|0",
+	}
+
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			var wg sync.WaitGroup
+			var errs = make(chan error, 64)
+			for g := 0; g < 32; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 200; i++ {
+						for ip, expect := range cases {
+							region, _, err := searcher.SearchByStrWithIOCount(ip)
+							if err != nil {
+								errs <- err
+								return
+							}
+							if region != expect {
+								errs <- fmt.Errorf("search `%s`: got `%s`, expect `%s`", ip, region, expect)
+								return
+							}
+						}
+					}
+				}()
+			}
+
+			// toggle the vector index cache while searching
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if err := searcher.LoadVectorIndex(); err != nil {
+						errs <- err
+						return
+					}
+					searcher.ClearVectorIndex()
+				}
+			}()
+
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestIPv6Search(t *testing.T) {
+	dbPath := writeTestDb(t, testSegmentsV6)
+	cases := map[string]string{
+		"::":                    "0|0|0|0",
+		"2001:250::1":           "0|0|0|0",
+		"2001:251::":            "中国|北京|北京市|教育网",
+		"2001:251::ffff":        "中国|北京|北京市|教育网",
+		"2001:251::1:0":         "美国|0|0|0",
+		"2400:1::":              "美国|0|0|0",
+		"2401::1":               "日本|东京都|0|0",
+		"ffff:ffff:ffff:ffff::": "日本|东京都|0|0",
+	}
+
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+
+			for ip, expect := range cases {
+				region, err := searcher.SearchByStr(ip)
+				require.NoError(t, err, ip)
+				assert.Equal(t, expect, region, ip)
+			}
+
+			// ipv4 lookups are rejected by the ipv6 xdb
+			_, err = searcher.Search(1)
+			assert.Error(t, err)
+			_, err = searcher.SearchByStr("1.2.3.4")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestIPv6SearchOnIPv4Db(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+
+	ip, err := CheckIPv6("2001:251::")
+	require.NoError(t, err)
+	_, err = searcher.SearchV6(ip)
+	assert.Error(t, err)
+	_, err = searcher.SearchByStr("2001:251::")
+	assert.Error(t, err)
+}
+
+func TestSearchRegion(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"country", "province", "city", "isp"}, searcher.GetHeader().FieldLayout)
+
+	region, err := searcher.SearchRegionByStr("2.12.134.1")
+	require.NoError(t, err)
+	assert.Equal(t, &Region{
+		Country:  "法国",
+		Province: "Ille-et-Vilaine",
+		ISP:      "橘子电信",
+		Raw:      "法国|Ille-et-Vilaine|0|橘子电信",
+	}, region)
+
+	v6Searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsV6))
+	require.NoError(t, err)
+	region, err = v6Searcher.SearchRegionByStr("2001:251::1")
+	require.NoError(t, err)
+	assert.Equal(t, "北京", region.Province)
+	assert.Equal(t, "北京市", region.City)
+	assert.Equal(t, "教育网", region.ISP)
+}
+
+func TestReaderAtSearcher(t *testing.T) {
+	buff := buildTestDb(t, testSegments)
+	searcher, err := NewWithReaderAt(bytes.NewReader(buff), int64(len(buff)))
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	region, ioCount, err := searcher.SearchByStrWithIOCount("2.12.134.0")
+	require.NoError(t, err)
+	assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+	assert.Equal(t, 4, ioCount)
+
+	require.NoError(t, searcher.LoadVectorIndex())
+	region, ioCount, err = searcher.SearchByStrWithIOCount("2.13.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "美国|0|0|0", region)
+	assert.Equal(t, 3, ioCount)
+
+	// truncated data
+	truncated := buff[:len(buff)-RegionIndexBlockSize]
+	searcher, err = NewWithReaderAt(bytes.NewReader(truncated), int64(len(truncated)))
+	require.NoError(t, err)
+	_, err = searcher.SearchByStr("255.255.255.255")
+	assert.Error(t, err)
+}
+
+func TestMmapSearcher(t *testing.T) {
+	dbPath := writeTestDb(t, testSegments)
+	mmapSe, err := NewWithMmap(dbPath)
+	require.NoError(t, err)
+	memorySe, err := Create(dbPath, CACHE_POLICY_MEMORY)
+	require.NoError(t, err)
+
+	for _, ip := range []string{"0.0.0.0", "2.12.134.0", "2.13.0.255", "255.255.255.255"} {
+		expect, err := memorySe.SearchByStr(ip)
+		require.NoError(t, err)
+		region, ioCount, err := mmapSe.SearchByStrWithIOCount(ip)
+		require.NoError(t, err)
+		assert.Equal(t, expect, region)
+		assert.Equal(t, 0, ioCount)
+	}
+
+	// close twice is fine
+	mmapSe.Close()
+	mmapSe.Close()
+}
+
+func TestCreateFromFS(t *testing.T) {
+	buff := buildTestDb(t, testSegments)
+	dbPath := writeTestDb(t, testSegments)
+	fsList := map[string]fs.FS{
+		"map": fstest.MapFS{"test.xdb": &fstest.MapFile{Data: buff}},
+		"dir": os.DirFS(filepath.Dir(dbPath)),
+	}
+
+	for fsName, fsys := range fsList {
+		for name, policy := range testCachePolicies {
+			t.Run(fsName+"/"+name, func(t *testing.T) {
+				searcher, err := CreateFromFS(fsys, "test.xdb", policy)
+				if policy == CACHE_POLICY_MMAP {
+					assert.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				region, err := searcher.SearchByStr("2.12.134.0")
+				require.NoError(t, err)
+				assert.Equal(t, "法国|Ille-et-Vilaine|0|橘子电信", region)
+			})
+		}
+	}
+
+	_, err := CreateFromFS(fsList["map"], "missing.xdb", CACHE_POLICY_FILE)
+	assert.Error(t, err)
+}
+
+func TestSearchNotFound(t *testing.T) {
+	dbPath := writeTestDb(t, testSegmentsPartial)
+	cases := map[string]string{
+		"0.0.0.0":         "",
+		"1.0.0.255":       "中国|广东省|深圳市|电信",
+		"1.0.1.5":         "",
+		"1.0.2.0":         "法国|0|0|橘子电信",
+		"2.12.134.0":      "",
+		"3.0.5.0":         "",
+		"3.1.0.0":         "美国|0|0|0",
+		"10.1.2.3":        "0|0|内网IP|内网IP",
+		"255.255.255.255": "",
+	}
+
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+			assert.True(t, searcher.GetHeader().IsPartial())
+
+			var ips []string
+			var expects []string
+			for ip, expect := range cases {
+				region, err := searcher.SearchByStr(ip)
+				_, rangeErr := searcher.SearchRangeByStr(ip)
+				if expect == "" {
+					assert.ErrorIs(t, err, ErrNotFound, ip)
+					assert.ErrorIs(t, rangeErr, ErrNotFound, ip)
+				} else {
+					require.NoError(t, err, ip)
+					require.NoError(t, rangeErr, ip)
+				}
+				assert.Equal(t, expect, region, ip)
+				ips, expects = append(ips, ip), append(expects, expect)
+			}
+
+			// the uncovered ips are empty in the batch search
+			regions, err := searcher.SearchBatchByStr(ips)
+			require.NoError(t, err)
+			assert.Equal(t, expects, regions)
+		})
+	}
+
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegmentsPartialV6))
+	require.NoError(t, err)
+	for ip, found := range map[string]bool{"::": false, "2001:250::1": true, "2001:251::1:0": false, "2001:251::2:1": true, "2001:251::3:0": false} {
+		_, err = searcher.SearchByStr(ip)
+		if found {
+			assert.NoError(t, err, ip)
+		} else {
+			assert.ErrorIs(t, err, ErrNotFound, ip)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 The Ip2Region Authors. All rights reserved.
+// Use of this source code is governed by a Apache2.0-style
+// license that can be found in the LICENSE file.
+
+// ---
+// search the xdb files made by the original ip2region maker.
+// the original xdb v2 shares the header version, the vector index and the index policy with the igr xdb,
+// while the segment index block and the region are different:
+// -- segment index block: [start ip 4B][end ip 4B][region length 2B][region ptr 4B], all little endian
+// -- region: the full region string, without the head and tail split
+// only header[0:16] is used by the original maker, and the igr maker always records the region head
+// start ptr in header[16:20], so a header with all zero bytes behind the first 16 is the original one.
+// the region ptr of the original xdb is passed around with its length as [length 2B][ptr 4B],
+// so the segments located share the same code with the igr xdb.
+
+package xdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// UpstreamIndexBlockSize is the segment index block size of the original xdb
+const UpstreamIndexBlockSize = 14
+
+// Format is the layout of the xdb data, detected from the header
+type Format int
+
+const (
+	IGRFormat Format = iota
+	UpstreamFormat
+)
+
+func (f Format) String() string {
+	switch f {
+	case IGRFormat:
+		return "igr"
+	case UpstreamFormat:
+		return "ip2region"
+	default:
+		return "unknown"
+	}
+}
+
+// isUpstreamHeader check if the header is written by the original ip2region maker
+func isUpstreamHeader(input []byte) bool {
+	if len(input) < HeaderInfoLength {
+		return false
+	}
+
+	for _, b := range input[16:HeaderInfoLength] {
+		if b != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateUpstream check the header pointers of the original xdb against the size of the data
+func (h *Header) validateUpstream(size int64) error {
+	var err error
+	switch {
+	case h.IndexPolicy != VectorIndexPolicy && h.IndexPolicy != BTreeIndexPolicy:
+		err = fmt.Errorf("invalid index policy `%d`", h.IndexPolicy)
+	case int64(h.StartIndexPtr) < HeaderInfoLength+VectorIndexLength || h.StartIndexPtr > h.EndIndexPtr:
+		err = fmt.Errorf("invalid segment index range (%d, %d)", h.StartIndexPtr, h.EndIndexPtr)
+	case (h.EndIndexPtr-h.StartIndexPtr)%UpstreamIndexBlockSize != 0 || int64(h.EndIndexPtr)+UpstreamIndexBlockSize > size:
+		err = fmt.Errorf("segment index range (%d, %d) out of the data size %d", h.StartIndexPtr, h.EndIndexPtr, size)
+	}
+
+	if err != nil {
+		return &CorruptionError{Section: SectionHeader, Err: err}
+	}
+
+	return nil
+}
+
+// upstreamRegionPtr pack the region ptr and length of the original xdb into one region ptr
+func upstreamRegionPtr(buff []byte) int64 {
+	return int64(binary.LittleEndian.Uint16(buff[8:]))<<32 | int64(binary.LittleEndian.Uint32(buff[10:]))
+}
+
+// locateUpstream binary search the segment index blocks of the vector slot of the original xdb
+func (s *Searcher) locateUpstream(ip uint32, lk *lookup, ioCount *int) (regionPtr int64, startIP uint32, endIP uint32, err error) {
+	sPtr, ePtr, err := s.vectorBlock((ip>>24)&0xFF, (ip>>16)&0xFF, lk, ioCount)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var l, h = 0, int(ePtr-sPtr)/UpstreamIndexBlockSize - 1
+	for l <= h && ePtr > sPtr {
+		m := (l + h) >> 1
+		p := sPtr + uint32(m*UpstreamIndexBlockSize)
+		buff, err := s.view(int64(p), UpstreamIndexBlockSize, lk.index[:], ioCount)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("read segment index at %d: %w", p, err)
+		}
+
+		sip := binary.LittleEndian.Uint32(buff)
+		if ip < sip {
+			h = m - 1
+		} else {
+			eip := binary.LittleEndian.Uint32(buff[4:])
+			if ip > eip {
+				l = m + 1
+			} else {
+				return upstreamRegionPtr(buff), sip, eip, nil
+			}
+		}
+	}
+
+	return 0, 0, 0, fmt.Errorf("%w: %s", ErrNotFound, Long2IP(ip))
+}
+
+// appendUpstreamRegion append the full region string of the original xdb to dst
+func (s *Searcher) appendUpstreamRegion(dst []byte, regionPtr int64, lk *lookup, ioCount *int) ([]byte, error) {
+	var ptr, length = regionPtr & 0xFFFFFFFF, int(regionPtr >> 32)
+	var buff = lk.tail
+	if length > len(buff) {
+		buff = make([]byte, length)
+	}
+
+	region, err := s.view(ptr, length, buff, ioCount)
+	if err != nil {
+		return dst, fmt.Errorf("read region data at %d: %w", ptr, err)
+	}
+
+	return append(dst, region...), nil
+}
+
+// splitUpstreamRegion split the full region string into the head and tail as the igr maker does,
+// the head is the first three fields, or all but the last field for the shorter regions
+func splitUpstreamRegion(region string) (head string, tail string) {
+	pieces := strings.SplitN(region, REGION_STR_SEP, 4)
+	if len(pieces) == 4 {
+		return strings.Join(pieces[:3], REGION_STR_SEP), pieces[3]
+	}
+
+	idx := strings.LastIndex(region, REGION_STR_SEP)
+	if idx < 0 {
+		return region, ""
+	}
+
+	return region[:idx], region[idx+1:]
+}
@@ -0,0 +1,108 @@
+package xdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamSearch(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0":         "中国|广东省|深圳市|电信",
+		"2.12.133.255":    "中国|广东省|深圳市|电信",
+		"2.12.134.0":      "法国|Ille-et-Vilaine|0|橘子电信",
+		"2.12.140.0":      "法国|0|0|橘子电信",
+		"2.13.0.255":      "法国|0|0|橘子电信",
+		"2.13.1.0":        "美国|0|0|0",
+		"255.255.255.255": "美国|0|0|0",
+	}
+
+	var buff = buildUpstreamTestDb(t, testSegments)
+	dbPath := writeTestFile(t, buff)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+			assert.Equal(t, UpstreamFormat, searcher.GetHeader().Format)
+			assert.Equal(t, IPv4, searcher.GetHeader().IPVersion)
+			require.NoError(t, searcher.GetHeader().Validate(int64(len(buff))))
+
+			for ip, expect := range cases {
+				region, err := searcher.SearchByStr(ip)
+				require.NoError(t, err, ip)
+				assert.Equal(t, expect, region, ip)
+
+				dst, err := searcher.AppendSearchByStr([]byte("r:"), ip)
+				require.NoError(t, err, ip)
+				assert.Equal(t, "r:"+expect, string(dst), ip)
+			}
+
+			// the range is split with the /16 boundary by the original maker too
+			r, err := searcher.SearchRangeByStr("2.13.0.1")
+			require.NoError(t, err)
+			assert.Equal(t, "2.13.0.0|2.13.0.255|法国|0|0|橘子电信", r.String())
+
+			regions, err := searcher.SearchBatchByStr([]string{"2.13.1.0", "0.0.0.0", "2.12.140.0"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"美国|0|0|0", "中国|广东省|深圳市|电信", "法国|0|0|橘子电信"}, regions)
+
+			var lines []string
+			it := searcher.NewSegmentIterator()
+			for it.Next() {
+				lines = append(lines, it.Segment().String())
+			}
+			require.NoError(t, it.Err())
+			var expects []string
+			for _, seg := range testSegments {
+				expects = append(expects, seg.sip+"|"+seg.eip+"|"+seg.head+"|"+seg.tail)
+			}
+			assert.Equal(t, expects, lines)
+		})
+	}
+}
+
+func TestUpstreamDetect(t *testing.T) {
+	searcher, err := NewWithBuffer(buildTestDb(t, testSegments))
+	require.NoError(t, err)
+	assert.Equal(t, IGRFormat, searcher.GetHeader().Format)
+
+	searcher, err = NewWithBuffer(buildUpstreamTestDb(t, testSegments))
+	require.NoError(t, err)
+	assert.Equal(t, UpstreamFormat, searcher.GetHeader().Format)
+
+	info, err := searcher.Info()
+	require.NoError(t, err)
+	assert.Equal(t, UpstreamFormat, info.Format)
+	assert.Nil(t, info.Metadata)
+
+	region, err := searcher.SearchRegion(0)
+	require.NoError(t, err)
+	assert.Equal(t, "广东省", region.Province)
+
+	_, err = searcher.SearchByStr("2001::1")
+	assert.ErrorIs(t, err, ErrInvalidIP)
+}
+
+func TestUpstreamCheck(t *testing.T) {
+	var buff = buildUpstreamTestDb(t, testSegments)
+	searcher, err := NewWithFileOnly(writeTestFile(t, buff))
+	require.NoError(t, err)
+	defer searcher.Close()
+
+	report, err := searcher.Check()
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%v", report.Problems)
+	assert.Equal(t, len(testSegments), report.Tails)
+
+	// a region out of the region block
+	var header = searcher.GetHeader()
+	buff[header.StartIndexPtr+8] = 0xFF
+	buff[header.StartIndexPtr+9] = 0xFF
+	corrupted, err := NewWithBuffer(buff)
+	require.NoError(t, err)
+	report, err = corrupted.Check()
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+}
@@ -9,7 +9,11 @@
 package xdb
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -17,21 +21,29 @@ import (
 
 var shiftIndex = []int{24, 16, 8, 0}
 
+// CheckIP parse the ipv4 address string to the long ip,
+// the error returned is an ErrInvalidIP for the malformed address
 func CheckIP(ip string) (uint32, error) {
-	var ps = strings.Split(ip, ".")
-	if len(ps) != 4 {
-		return 0, fmt.Errorf("invalid ip address `%s`", ip)
+	// split the parts without allocation, it is on the path of every string search
+	if strings.Count(ip, ".") != 3 {
+		return 0, fmt.Errorf("%w `%s`", ErrInvalidIP, ip)
 	}
 
 	var val = uint32(0)
-	for i, s := range ps {
+	var rest = ip
+	for i := 0; i < 4; i++ {
+		var s = rest
+		if idx := strings.IndexByte(rest, '.'); idx >= 0 {
+			s, rest = rest[:idx], rest[idx+1:]
+		}
+
 		d, err := strconv.Atoi(s)
 		if err != nil {
-			return 0, fmt.Errorf("the %dth part `%s` is not an integer", i, s)
+			return 0, fmt.Errorf("%w `%s`: the %dth part `%s` is not an integer", ErrInvalidIP, ip, i, s)
 		}
 
 		if d < 0 || d > 255 {
-			return 0, fmt.Errorf("the %dth part `%s` should be an integer bettween 0 and 255", i, s)
+			return 0, fmt.Errorf("%w `%s`: the %dth part `%s` should be an integer bettween 0 and 255", ErrInvalidIP, ip, i, s)
 		}
 
 		val |= uint32(d) << shiftIndex[i]
@@ -45,28 +57,90 @@ func Long2IP(ip uint32) string {
 	return fmt.Sprintf("%d.%d.%d.%d", (ip>>24)&0xFF, (ip>>16)&0xFF, (ip>>8)&0xFF, ip&0xFF)
 }
 
+// Long2Addr convert the long ip to netip.Addr
+func Long2Addr(ip uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
+
+// addrToLong convert the ipv4 netip.Addr to the long ip
+func addrToLong(addr netip.Addr) uint32 {
+	ip := addr.As4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+// CheckIPv6 parse the ipv6 address string to its 16 bytes form
+func CheckIPv6(ip string) ([16]byte, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() || addr.Zone() != "" {
+		return [16]byte{}, fmt.Errorf("%w `%s`: not an ipv6 address", ErrInvalidIP, ip)
+	}
+
+	return addr.As16(), nil
+}
+
+// IPv6ToString return the canonical string of the 16 bytes ipv6 address
+func IPv6ToString(ip [16]byte) string {
+	return netip.AddrFrom16(ip).String()
+}
+
 func MidIP(sip uint32, eip uint32) uint32 {
 	return uint32((uint64(sip) + uint64(eip)) >> 1)
 }
 
-// LoadHeader load the header info from the specified handle
-func LoadHeader(handle *os.File) (*Header, error) {
-	_, err := handle.Seek(0, 0)
+// LoadHeader load the header info from the specified handle,
+// any io.ReaderAt could be used as the handle
+func LoadHeader(handle io.ReaderAt) (*Header, error) {
+	var buff = make([]byte, HeaderInfoLength)
+	err := readFullAt(handle, buff, 0)
 	if err != nil {
-		return nil, fmt.Errorf("seek to the header: %w", err)
+		return nil, fmt.Errorf("read the header: %w", err)
 	}
 
-	var buff = make([]byte, HeaderInfoLength)
-	rLen, err := handle.Read(buff)
-	if err != nil {
-		return nil, err
+	return NewHeader(buff)
+}
+
+// Validate check the header info against the size of the xdb data,
+// to make sure all the pointers are inside the data.
+// the error returned is an ErrUnsupportedVersion or a CorruptionError of the header or the vector index.
+func (h *Header) Validate(size int64) error {
+	if h.Version != VersionNo && h.Version != VersionExtended {
+		return fmt.Errorf("%w `%d`", ErrUnsupportedVersion, h.Version)
 	}
 
-	if rLen != len(buff) {
-		return nil, fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+	// the vector index is searched without the header pointers
+	if size < HeaderInfoLength+VectorIndexLength {
+		return &CorruptionError{Section: SectionVectorIndex, Err: fmt.Errorf("data size %d less than the header and the vector index", size)}
 	}
 
-	return NewHeader(buff)
+	if h.Format == UpstreamFormat {
+		return h.validateUpstream(size)
+	}
+
+	var err error
+	switch {
+	case h.IndexPolicy != VectorIndexPolicy && h.IndexPolicy != BTreeIndexPolicy:
+		err = fmt.Errorf("invalid index policy `%d`", h.IndexPolicy)
+	case (h.WideHeadOffset() || h.VarintLength()) && h.Version < VersionExtended:
+		err = fmt.Errorf("region record extension flags `%d` with the version `%d`", h.Flags, h.Version)
+	case int64(h.RegionHeadStartPtr) < HeaderInfoLength+VectorIndexLength || int64(h.RegionHeadStartPtr) >= size:
+		err = fmt.Errorf("region head start ptr %d out of range", h.RegionHeadStartPtr)
+	case h.StartIndexPtr < h.RegionHeadStartPtr || h.StartIndexPtr > h.EndIndexPtr:
+		err = fmt.Errorf("invalid segment index range (%d, %d)", h.StartIndexPtr, h.EndIndexPtr)
+	case int64(h.EndIndexPtr) > size:
+		// the end ptr could be the end of the data when the last segment is reserved
+		err = fmt.Errorf("segment index end ptr %d out of the data size %d", h.EndIndexPtr, size)
+	case h.IndexPolicy == BTreeIndexPolicy && ((h.EndIndexPtr-h.StartIndexPtr)%h.IndexBlockSize() != 0 || int64(h.EndIndexPtr)+int64(h.IndexBlockSize()) > size):
+		// the whole btree segment index is searched with the header pointers
+		err = fmt.Errorf("invalid btree segment index range (%d, %d)", h.StartIndexPtr, h.EndIndexPtr)
+	case h.MetadataLength > 0 && (h.MetadataPtr < h.RegionHeadStartPtr || int64(h.MetadataPtr)+int64(h.MetadataLength) > size):
+		err = fmt.Errorf("metadata section (%d, %d) out of range", h.MetadataPtr, h.MetadataLength)
+	}
+
+	if err != nil {
+		return &CorruptionError{Section: SectionHeader, Err: err}
+	}
+
+	return nil
 }
 
 // LoadHeaderFromFile load header info from the specified db file path
@@ -87,25 +161,21 @@ func LoadHeaderFromFile(dbFile string) (*Header, error) {
 
 // LoadHeaderFromBuff wrap the header info from the content buffer
 func LoadHeaderFromBuff(cBuff []byte) (*Header, error) {
-	return NewHeader(cBuff[0:256])
+	if len(cBuff) < HeaderInfoLength {
+		return nil, &CorruptionError{Section: SectionHeader, Err: fmt.Errorf("invalid xdb size %d", len(cBuff))}
+	}
+
+	return NewHeader(cBuff[0:HeaderInfoLength])
 }
 
-// LoadVectorIndex util function to load the vector index from the specified file handle
-func LoadVectorIndex(handle *os.File) ([]byte, error) {
+// LoadVectorIndex util function to load the vector index from the specified file handle,
+// any io.ReaderAt could be used as the handle
+func LoadVectorIndex(handle io.ReaderAt) ([]byte, error) {
 	// load all the vector index block
-	_, err := handle.Seek(HeaderInfoLength, 0)
-	if err != nil {
-		return nil, fmt.Errorf("seek to vector index: %w", err)
-	}
-
 	var buff = make([]byte, VectorIndexRows*VectorIndexCols*VectorIndexSize)
-	rLen, err := handle.Read(buff)
+	err := readFullAt(handle, buff, HeaderInfoLength)
 	if err != nil {
-		return nil, err
-	}
-
-	if rLen != len(buff) {
-		return nil, fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+		return nil, fmt.Errorf("read vector index: %w", err)
 	}
 
 	return buff, nil
@@ -135,22 +205,15 @@ func LoadContent(handle *os.File) ([]byte, error) {
 		return nil, fmt.Errorf("stat: %w", err)
 	}
 
-	size := fi.Size()
-
-	// seek to the head of the file
-	_, err = handle.Seek(0, 0)
-	if err != nil {
-		return nil, fmt.Errorf("seek to get xdb file length: %w", err)
-	}
+	return LoadContentFromReaderAt(handle, fi.Size())
+}
 
+// LoadContentFromReaderAt load the whole xdb content of the specified size from the io.ReaderAt
+func LoadContentFromReaderAt(reader io.ReaderAt, size int64) ([]byte, error) {
 	var buff = make([]byte, size)
-	rLen, err := handle.Read(buff)
+	err := readFullAt(reader, buff, 0)
 	if err != nil {
-		return nil, err
-	}
-
-	if rLen != len(buff) {
-		return nil, fmt.Errorf("incomplete read: readed bytes should be %d", len(buff))
+		return nil, fmt.Errorf("read xdb content: %w", err)
 	}
 
 	return buff, nil
@@ -172,6 +235,29 @@ func LoadContentFromFile(dbFile string) ([]byte, error) {
 	return cBuff, nil
 }
 
+// readFullAt read len(buff) bytes from the reader at the offset
+func readFullAt(reader io.ReaderAt, buff []byte, offset int64) error {
+	rLen, err := reader.ReadAt(buff, offset)
+	if rLen != len(buff) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return fmt.Errorf("%w: incomplete read, readed bytes should be %d", ErrCorruptDB, len(buff))
+	}
+
+	return nil
+}
+
+// LoadContentFromFS load the whole xdb content from the named file of the fs.FS
+func LoadContentFromFS(fsys fs.FS, name string) ([]byte, error) {
+	cBuff, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("read xdb file `%s`: %w", name, err)
+	}
+
+	return cBuff, nil
+}
+
 // return
 func ParseDynamicBytes(buff []byte) ([]byte, uint8) {
 	length := int(buff[0])
@@ -77,4 +77,5 @@ func TestLoadHeader(t *testing.T) {
 	fmt.Printf("StartIndexPtr  : %d\n", header.StartIndexPtr)
 	fmt.Printf("EndIndexPtr    : %d\n", header.EndIndexPtr)
 	fmt.Printf("RegionHeadStartPtr    : %d\n", header.RegionHeadStartPtr)
+	fmt.Printf("IPVersion      : %s\n", header.IPVersion.String())
 }
@@ -0,0 +1,107 @@
+package xdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSegmentsLong have the heads and tails over the 1 byte length limits
+var testSegmentsLong = []testSegment{
+	{"0.0.0.0", "2.12.133.255", "中国|广东省", "深圳市|电信"},
+	{"2.12.134.0", "2.12.139.255", "法国|" + strings.Repeat("Ille-et-Vilaine", 6), "0|橘子电信"},
+	{"2.12.140.0", "2.13.0.255", "法国|0", "0|橘子电信|" + strings.Repeat("AS3215", 50)},
+	{"2.13.1.0", "255.255.255.255", "美国|0", "0|0|" + strings.Repeat("America/New_York", 8)},
+}
+
+func TestVarintLengthSearch(t *testing.T) {
+	for _, policy := range []IndexPolicy{VectorIndexPolicy, BTreeIndexPolicy} {
+		dbPath := writeTestFile(t, setTestChecksums(t, buildTestDbWithPolicy(t, testSegmentsLong, policy)))
+		for name, cachePolicy := range testCachePolicies {
+			t.Run(policy.String()+"/"+name, func(t *testing.T) {
+				searcher, err := Create(dbPath, cachePolicy, WithVerify())
+				require.NoError(t, err)
+				defer searcher.Close()
+
+				var header = searcher.GetHeader()
+				assert.Equal(t, uint16(VersionExtended), header.Version)
+				assert.True(t, header.VarintLength())
+				assert.False(t, header.WideHeadOffset())
+
+				for _, seg := range testSegmentsLong {
+					for _, ip := range []string{seg.sip, seg.eip} {
+						region, err := searcher.SearchByStr(ip)
+						require.NoError(t, err, ip)
+						assert.Equal(t, seg.head+REGION_STR_SEP+seg.tail, region, ip)
+					}
+				}
+
+				assert.Equal(t, testSegmentsLong, collectSegments(t, searcher))
+			})
+		}
+
+		searcher, err := NewWithFileOnly(dbPath)
+		require.NoError(t, err)
+		report, err := searcher.Check()
+		require.NoError(t, err)
+		assert.True(t, report.OK(), "%v", report.Problems)
+		searcher.Close()
+	}
+}
+
+func TestVarintLengthWithIOCount(t *testing.T) {
+	// the same segments with the short records, made with the 1 byte length
+	var shortSegments = append([]testSegment(nil), testSegmentsLong...)
+	for i := range shortSegments {
+		shortSegments[i].head, shortSegments[i].tail = testSegments[0].head, testSegments[0].tail
+	}
+
+	// the short records are read in one io as the 1 byte length ones,
+	// the long head or tail costs one more io
+	dbPath, shortPath := writeTestDb(t, testSegmentsLong), writeTestDb(t, shortSegments)
+	for name, policy := range testCachePolicies {
+		t.Run(name, func(t *testing.T) {
+			searcher, err := Create(dbPath, policy)
+			require.NoError(t, err)
+			defer searcher.Close()
+			shortSearcher, err := Create(shortPath, policy)
+			require.NoError(t, err)
+			defer shortSearcher.Close()
+			assert.False(t, shortSearcher.GetHeader().VarintLength())
+
+			for i, ip := range []string{"0.0.0.0", "2.12.134.0", "2.12.140.0"} {
+				_, ioCount, err := searcher.SearchByStrWithIOCount(ip)
+				require.NoError(t, err)
+				_, expect, err := shortSearcher.SearchByStrWithIOCount(ip)
+				require.NoError(t, err)
+
+				if i > 0 && expect > 0 {
+					expect++
+				}
+				assert.Equal(t, expect, ioCount, ip)
+			}
+		})
+	}
+}
+
+func TestVarintLengthCheck(t *testing.T) {
+	// a length prefix running out of the region block
+	var buff = buildTestDb(t, testSegmentsLong)
+	header, err := LoadHeaderFromBuff(buff)
+	require.NoError(t, err)
+	for i := header.RegionHeadStartPtr; i < header.StartIndexPtr; i++ {
+		buff[i] = 0xFF
+	}
+
+	searcher, err := NewWithFileOnly(writeTestFile(t, setTestChecksums(t, buff)))
+	require.NoError(t, err)
+	defer searcher.Close()
+	report, err := searcher.Check()
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+
+	_, err = searcher.SearchByStr("1.2.3.4")
+	assert.ErrorIs(t, err, ErrCorruptDB)
+}